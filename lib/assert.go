@@ -0,0 +1,76 @@
+package sequoia
+
+/* Assert.go
+ *
+ * Evaluates action assertions against captured
+ * vars, container exit codes and cluster state
+ */
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// evaluate each assertion as its own test point
+func (t *Test) CheckAssertions(scope *Scope, aliasKey string, asserts []string) {
+
+	failed := false
+	for _, assert := range asserts {
+		expr := strings.Replace(assert, "__self__", aliasKey, -1)
+		pass, rv, err := EvalCondition(scope, expr)
+		msg := fmt.Sprintf("%s => %s", strings.TrimSpace(assert), rv)
+		if err != nil {
+			msg = fmt.Sprintf("%s => %s", strings.TrimSpace(assert), err)
+		}
+		if pass == false {
+			if values := AssertOperands(scope, expr); len(values) > 0 {
+				msg = fmt.Sprintf("%s (%s)", msg, strings.Join(values, ", "))
+			}
+			msg = UtilTaskMsg("[assert]", msg)
+			ecolorsay(msg)
+			t.Cm.TapHandle.Ok(false, msg)
+			failed = true
+		} else {
+			msg = UtilTaskMsg("[assert]", msg)
+			colorsay(msg)
+			t.Cm.TapHandle.Ok(true, msg)
+		}
+	}
+
+	if failed == true {
+		t.HandleError(scope)
+	}
+}
+
+// evaluate the operands of an assertion's function call,
+// ie.. eq (.Var `count`) 10 yields [(.Var "count")=9 10=10]
+func AssertOperands(scope *Scope, expr string) []string {
+
+	tResolv := TemplateResolver{Scope: scope}
+	tmpl, err := template.New("t").Funcs(tResolv.FuncMap()).Parse(expr)
+	if err != nil {
+		return nil
+	}
+
+	values := []string{}
+	for _, node := range tmpl.Tree.Root.Nodes {
+		action, ok := node.(*parse.ActionNode)
+		if ok == false || len(action.Pipe.Cmds) == 0 {
+			continue
+		}
+		cmds := action.Pipe.Cmds
+		cmd := cmds[len(cmds)-1]
+		if _, ok := cmd.Args[0].(*parse.IdentifierNode); ok == false {
+			continue // not a function call
+		}
+		for _, arg := range cmd.Args[1:] {
+			src := arg.String()
+			if val, err := RenderTemplate(scope, "{{"+src+"}}"); err == nil {
+				values = append(values, fmt.Sprintf("%s=%s", src, val))
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,141 @@
+package sequoia
+
+/* Bucket.go
+ *
+ * Converts bucket spec settings to bucket-create
+ * args, validating values and the server version
+ * required by each setting
+ */
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type BucketSetting struct {
+	Flag       string
+	MinVersion float64
+	Values     []string
+	Required   bool // error instead of skip on older versions
+}
+
+// conflict resolution cannot be changed once a bucket
+// exists so it is never skipped
+var BucketSettings = map[string]BucketSetting{
+	"priority":            {"--bucket-priority", 4.5, []string{"low", "high"}, false},
+	"conflict_resolution": {"--conflict-resolution", 4.6, []string{"sequence", "timestamp", "custom"}, true},
+	"compression":         {"--compression-mode", 5.5, []string{"off", "passive", "active"}, false},
+	"max_ttl":             {"--max-ttl", 5.5, nil, false},
+	"durability":          {"--durability-min-level", 6.5, []string{"none", "majority", "majorityAndPersistActive", "persistToMajority"}, false},
+}
+
+// eviction policies allowed by bucket type
+var BucketEvictionPolicies = map[string][]string{
+	"couchbase": {"valueOnly", "fullEviction"},
+	"ephemeral": {"noEviction", "nruEviction"},
+	"memcached": {},
+}
+
+// optional bucket-create args for settings supported by version,
+// unsupported settings are skipped and invalid values are an error
+func BucketCreateArgs(bucket BucketSpec, version string) ([]string, error) {
+
+	args := []string{}
+	vMajor, _ := strconv.ParseFloat(version, 64)
+
+	if _, ok := BucketEvictionPolicies[bucket.Type]; ok == false {
+		return args, fmt.Errorf("bucket %s: invalid type %q", bucket.Name, bucket.Type)
+	}
+	if bucket.Type == "ephemeral" && vMajor > 0 && vMajor < 5.0 {
+		return args, fmt.Errorf("bucket %s: ephemeral requires version 5.0", bucket.Name)
+	}
+
+	flush := "1"
+	if bucket.Flush != nil && *bucket.Flush == false {
+		flush = "0"
+	}
+	args = append(args, "--enable-flush", flush)
+
+	if bucket.Eviction != "" {
+		if InList(bucket.Eviction, BucketEvictionPolicies[bucket.Type]) == false {
+			return args, fmt.Errorf("bucket %s: eviction %q not valid for %s bucket",
+				bucket.Name, bucket.Eviction, bucket.Type)
+		}
+		args = append(args, "--bucket-eviction-policy", bucket.Eviction)
+	}
+
+	maxTTL := ""
+	if bucket.MaxTTL != nil {
+		maxTTL = strconv.Itoa(*bucket.MaxTTL)
+	}
+	values := map[string]string{
+		"priority":            bucket.Priority,
+		"conflict_resolution": ConflictResolutionType(bucket.ConflictResolution),
+		"compression":         bucket.Compression,
+		"max_ttl":             maxTTL,
+		"durability":          bucket.Durability,
+	}
+
+	for _, key := range []string{"priority", "conflict_resolution",
+		"compression", "max_ttl", "durability"} {
+		val := values[key]
+		if val == "" {
+			continue
+		}
+		setting := BucketSettings[key]
+		if setting.Values != nil && InList(val, setting.Values) == false {
+			return args, fmt.Errorf("bucket %s: invalid %s %q, expected one of %v",
+				bucket.Name, key, val, setting.Values)
+		}
+		if vMajor > 0 && vMajor < setting.MinVersion && setting.Required == true {
+			return args, fmt.Errorf("bucket %s: %s requires version %.1f, cluster is %s",
+				bucket.Name, key, setting.MinVersion, version)
+		}
+		if vMajor > 0 && vMajor < setting.MinVersion {
+			ecolorsay(fmt.Sprintf("bucket %s: %s requires version %.1f, skipping",
+				bucket.Name, key, setting.MinVersion))
+			continue
+		}
+		args = append(args, setting.Flag, val)
+	}
+
+	return args, nil
+}
+
+// bucket-edit args of settings that can be changed once
+// a bucket exists, which excludes conflict resolution
+func BucketEditArgs(bucket BucketSpec, version string) ([]string, error) {
+	args, err := BucketCreateArgs(bucket, version)
+	if err != nil {
+		return args, err
+	}
+	editArgs := []string{}
+	for i := 0; i+1 < len(args); i += 2 {
+		if args[i] != BucketSettings["conflict_resolution"].Flag {
+			editArgs = append(editArgs, args[i], args[i+1])
+		}
+	}
+	return editArgs, nil
+}
+
+// checks settings of all buckets against version
+// so that errors are found before provisioning
+func ValidateBucketSpecs(spec ScopeSpec, version string) error {
+	for _, server := range spec.Servers {
+		for _, bucket := range server.BucketSpecs {
+			if _, err := BucketCreateArgs(bucket, version); err != nil {
+				return fmt.Errorf("cluster %s: %s", server.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func InList(val string, list []string) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package sequoia
+
+/* Capture.go
+ *
+ * Extracts values from the output of exited
+ * containers and saves them as typed variables
+ * that can be referenced with {{.Var `name`}}
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CaptureSpec struct {
+	Name     string
+	Regex    string
+	JsonPath string `yaml:"json_path"`
+	Last     bool
+	Type     string
+}
+
+// capture output and evaluate assertions once container has exited
+func (t *Test) HandleActionResults(scope *Scope, cid, aliasKey string, captures []CaptureSpec, asserts []string) {
+
+	if WaitForExit(scope, cid) == false {
+		// results of removed container are failures
+		removed := "container " + cid[:6] + " was removed"
+		for _, capture := range captures {
+			msg := UtilTaskMsg("[capture]", fmt.Sprintf("%s: %s", capture.Name, removed))
+			ecolorsay(msg)
+			t.Cm.TapHandle.Ok(false, msg)
+		}
+		for _, assert := range asserts {
+			msg := UtilTaskMsg("[assert]", fmt.Sprintf("%s => %s", strings.TrimSpace(assert), removed))
+			ecolorsay(msg)
+			t.Cm.TapHandle.Ok(false, msg)
+		}
+		if len(asserts) > 0 {
+			t.HandleError(scope)
+		}
+		return
+	}
+	t.CaptureOutput(scope, cid, captures)
+	t.CheckAssertions(scope, aliasKey, asserts)
+}
+
+// blocks until container exits, false if container was removed
+func WaitForExit(scope *Scope, cid string) bool {
+	for {
+		status, err := scope.Cm.GetStatus(cid)
+		if err != nil {
+			return false
+		}
+		if status == "exited" {
+			return true
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// capture values from container output
+func (t *Test) CaptureOutput(scope *Scope, cid string, captures []CaptureSpec) {
+
+	if len(captures) == 0 {
+		return
+	}
+	output := scope.Cm.GetLogs(cid, "all")
+	for _, capture := range captures {
+		val, err := capture.Extract(output)
+		if err != nil {
+			msg := UtilTaskMsg("[capture]", fmt.Sprintf("%s: %s", capture.Name, err))
+			ecolorsay(msg)
+			t.Cm.TapHandle.Ok(false, msg)
+			continue
+		}
+		scope.SetCapturedVar(capture.Name, val)
+		msg := UtilTaskMsg("[capture]", fmt.Sprintf("%s = %v", capture.Name, val))
+		colorsay(msg)
+		t.Cm.TapHandle.Ok(true, msg)
+	}
+}
+
+// extract value from output by regex, json path or last line
+func (c *CaptureSpec) Extract(output string) (interface{}, error) {
+
+	if c.Name == "" {
+		return nil, errors.New("capture requires a name")
+	}
+
+	switch {
+	case c.Regex != "":
+		re, err := regexp.Compile(c.Regex)
+		if err != nil {
+			return nil, err
+		}
+		// use last match in output with first group if provided
+		matches := re.FindAllStringSubmatch(output, -1)
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no match for %q", c.Regex)
+		}
+		match := matches[len(matches)-1]
+		raw := match[0]
+		if len(match) > 1 {
+			raw = match[1]
+		}
+		return ConvertCaptureValue(raw, c.Type)
+
+	case c.JsonPath != "":
+		data, err := ParseJsonOutput(output)
+		if err != nil {
+			return nil, err
+		}
+		val, err := JsonPathLookup(data, c.JsonPath)
+		if err != nil {
+			return nil, err
+		}
+		if str, ok := val.(string); ok {
+			return ConvertCaptureValue(str, c.Type)
+		}
+		if f, ok := val.(float64); ok && c.Type == "int" {
+			return int(f), nil
+		}
+		if c.Type != "" && c.Type != "json" {
+			return ConvertCaptureValue(fmt.Sprintf("%v", val), c.Type)
+		}
+		return val, nil
+
+	default:
+		// last non-empty line of output
+		return ConvertCaptureValue(LastLine(output), c.Type)
+	}
+}
+
+// converts raw string to requested type,
+// type is inferred when not specified
+func ConvertCaptureValue(raw, typ string) (interface{}, error) {
+	raw = strings.TrimSpace(raw)
+	switch typ {
+	case "string":
+		return raw, nil
+	case "int":
+		return strconv.Atoi(raw)
+	case "float":
+		return strconv.ParseFloat(raw, 64)
+	case "bool":
+		return strconv.ParseBool(raw)
+	case "json":
+		var v interface{}
+		err := json.Unmarshal([]byte(raw), &v)
+		return v, err
+	case "":
+		if v, err := strconv.Atoi(raw); err == nil {
+			return v, nil
+		}
+		if v, err := strconv.ParseFloat(raw, 64); err == nil {
+			return v, nil
+		}
+		if v, err := strconv.ParseBool(raw); err == nil {
+			return v, nil
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("unknown capture type %q", typ)
+}
+
+// parse output as json, falling back to last line
+// when output contains more than a single document
+func ParseJsonOutput(output string) (interface{}, error) {
+	var data interface{}
+	err := json.Unmarshal([]byte(output), &data)
+	if err != nil {
+		err = json.Unmarshal([]byte(LastLine(output)), &data)
+	}
+	return data, err
+}
+
+// walk a dot separated path through decoded json, ie..
+// results.0.count
+func JsonPathLookup(data interface{}, path string) (interface{}, error) {
+	val := data
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			continue
+		}
+		switch node := val.(type) {
+		case map[string]interface{}:
+			v, ok := node[key]
+			if ok == false {
+				return nil, fmt.Errorf("no such key %q in path %q", key, path)
+			}
+			val = v
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("invalid index %q in path %q", key, path)
+			}
+			val = node[i]
+		default:
+			return nil, fmt.Errorf("cannot lookup %q in path %q", key, path)
+		}
+	}
+	return val, nil
+}
+
+func LastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,223 @@
+package sequoia
+
+/* Clean.go
+ *
+ * Containers and services created by sequoia are labeled
+ * with the id of the run that created them and the sequoia
+ * version.  Clean mode removes them by label along with
+ * their logs, ie..
+ *
+ *   ./sequoia clean -run 20170102-150405-ab12cd
+ *   ./sequoia clean -older_than 24h -dry_run
+ */
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/fsouza/go-dockerclient"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	LABEL_RUN     = "sequoia.run"
+	LABEL_VERSION = "sequoia.version"
+)
+
+// version of sequoia in resource labels, set at build time with
+// -ldflags "-X github.com/EricACooper/sequoia/lib.SequoiaVersion=<version>"
+var SequoiaVersion = "dev"
+
+// id of this run, a resumed run keeps
+// the id of its saved state
+var RunID = NewRunID()
+
+func NewRunID() string {
+	suffix := strings.ToLower(RandStr(8))
+	if len(suffix) > 6 {
+		suffix = suffix[:6]
+	}
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), suffix)
+}
+
+func ResourceLabels() map[string]string {
+	return map[string]string{
+		LABEL_RUN:     RunID,
+		LABEL_VERSION: SequoiaVersion,
+	}
+}
+
+func addLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	for k, v := range ResourceLabels() {
+		labels[k] = v
+	}
+	return labels
+}
+
+func LabelContainerOptions(opts *docker.CreateContainerOptions) {
+	if opts.Config == nil {
+		opts.Config = &docker.Config{}
+	}
+	opts.Config.Labels = addLabels(opts.Config.Labels)
+}
+
+// labels service and the containers of its tasks
+func LabelServiceOptions(opts *docker.CreateServiceOptions) {
+	opts.ServiceSpec.Annotations.Labels = addLabels(opts.ServiceSpec.Annotations.Labels)
+	containerSpec := &opts.ServiceSpec.TaskTemplate.ContainerSpec
+	containerSpec.Labels = addLabels(containerSpec.Labels)
+}
+
+// label filter for all runs or a single run
+func labelFilters(run string) map[string][]string {
+	label := LABEL_RUN
+	if run != "" {
+		label = LABEL_RUN + "=" + run
+	}
+	return map[string][]string{"label": []string{label}}
+}
+
+// containers created by sequoia, all runs when run is empty
+func (cm *ContainerManager) LabeledContainers(run string) []docker.APIContainers {
+	labeled := []docker.APIContainers{}
+	for _, client := range cm.AllClients() {
+		opts := docker.ListContainersOptions{All: true, Filters: labelFilters(run)}
+		containers, err := client.ListContainers(opts)
+		chkerr(err)
+		labeled = append(labeled, containers...)
+	}
+	return labeled
+}
+
+// services created by sequoia, all runs when run is empty
+func (cm *ContainerManager) LabeledServices(run string) []swarm.Service {
+	opts := docker.ListServicesOptions{Filters: labelFilters(run)}
+	services, err := cm.Client.ListServices(opts)
+	chkerr(err)
+	return services
+}
+
+func containerName(c docker.APIContainers) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return c.ID[:6]
+}
+
+// clean mode, removes labeled resources and logs
+func RunClean(flags TestFlags) {
+
+	var olderThan time.Duration
+	if *flags.OlderThan != "" {
+		var err error
+		olderThan, err = time.ParseDuration(*flags.OlderThan)
+		logerr(err)
+	}
+	cutoff := time.Now().Add(-olderThan)
+	isOld := func(created time.Time) bool {
+		return olderThan == 0 || created.Before(cutoff)
+	}
+
+	verb := "remove"
+	if *flags.DryRun == true {
+		verb = "would remove"
+	}
+	run := *flags.CleanRun
+	cm := NewContainerManager(*flags.Client, *flags.Provider)
+
+	var services, containers, logs int
+	if *flags.CleanContainers == true && cm.ProviderType == "swarm" {
+		for _, svc := range cm.LabeledServices(run) {
+			if isOld(svc.CreatedAt) == false {
+				continue
+			}
+			if *flags.DryRun == false {
+				if err := cm.RemoveService(svc.ID); err != nil {
+					ecolorsay(fmt.Sprintf("error removing service %s: %s", svc.Spec.Name, err))
+					continue
+				}
+			}
+			colorsay(fmt.Sprintf("%s service %s %s (run %s, sequoia %s)", verb,
+				svc.ID[:6], svc.Spec.Name,
+				svc.Spec.Labels[LABEL_RUN], svc.Spec.Labels[LABEL_VERSION]))
+			services++
+		}
+	}
+
+	// logs of a run are those of its containers
+	runIDs := []string{}
+	for _, c := range cm.LabeledContainers(run) {
+		if isOld(time.Unix(c.Created, 0)) == false {
+			continue
+		}
+		runIDs = append(runIDs, c.ID[:6])
+		if *flags.CleanContainers == false {
+			continue
+		}
+		if *flags.DryRun == false {
+			if err := cm.RemoveContainer(c.ID); err != nil {
+				ecolorsay(fmt.Sprintf("error removing %s: %s", containerName(c), err))
+				continue
+			}
+		}
+		colorsay(fmt.Sprintf("%s container %s %s (run %s, sequoia %s)", verb,
+			c.ID[:6], containerName(c),
+			c.Labels[LABEL_RUN], c.Labels[LABEL_VERSION]))
+		containers++
+	}
+
+	if *flags.CleanLogs == true {
+		logs = CleanLogFiles(*flags.LogDir, run, runIDs, isOld, *flags.DryRun)
+	}
+
+	colorsay(fmt.Sprintf("%s %d services, %d containers, %d log files", verb,
+		services, containers, logs))
+}
+
+// removes files of log dir, only the logs of given containers
+// when cleaning a single run
+func CleanLogFiles(logDir, run string, ids []string, isOld func(time.Time) bool, dryRun bool) int {
+	verb := "remove"
+	if dryRun == true {
+		verb = "would remove"
+	}
+
+	removed := 0
+	filepath.Walk(logDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() == true {
+			return nil
+		}
+		if isOld(info.ModTime()) == false {
+			return nil
+		}
+		if run != "" && isContainerLog(info.Name(), ids) == false {
+			return nil
+		}
+		if dryRun == false {
+			if err := os.Remove(path); err != nil {
+				ecolorsay(fmt.Sprintf("error removing %s: %s", path, err))
+				return nil
+			}
+		}
+		colorsay(fmt.Sprintf("%s log %s", verb, path))
+		removed++
+		return nil
+	})
+	return removed
+}
+
+// container logs are named <image>_<id>, see ContainerLogFile
+func isContainerLog(name string, ids []string) bool {
+	name = strings.TrimSuffix(name, ".tar")
+	for _, id := range ids {
+		if strings.HasSuffix(name, "_"+id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package sequoia
+
+/* Collection.go
+ *
+ * Creates scopes and collections declared
+ * within bucket spec
+ */
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// collection created within a bucket scope
+type Keyspace struct {
+	Bucket     string
+	Scope      string
+	Collection string
+	MaxTTL     *int
+}
+
+func (k Keyspace) String() string {
+	return fmt.Sprintf("%s.%s.%s", k.Bucket, k.Scope, k.Collection)
+}
+
+// expanded scope names of bucket
+func (b *BucketSpec) ScopeNames() []string {
+	names := []string{}
+	for _, scope := range b.Scopes {
+		names = append(names, scope.Names...)
+	}
+	return names
+}
+
+// all keyspaces of bucket in order of spec
+func (b *BucketSpec) Keyspaces(bucketName string) []Keyspace {
+	keyspaces := []Keyspace{}
+	for _, scope := range b.Scopes {
+		for _, scopeName := range scope.Names {
+			for _, collection := range scope.Collections {
+				for _, collectionName := range collection.Names {
+					keyspaces = append(keyspaces, Keyspace{
+						Bucket:     bucketName,
+						Scope:      scopeName,
+						Collection: collectionName,
+						MaxTTL:     collection.MaxTTL,
+					})
+				}
+			}
+		}
+	}
+	return keyspaces
+}
+
+// collection names within nth scope of bucket
+func (b *BucketSpec) CollectionNames(scopeIdx int) []string {
+	names := []string{}
+	scopeNames := b.ScopeNames()
+	if scopeIdx >= len(scopeNames) {
+		return names
+	}
+	for _, keyspace := range b.Keyspaces(b.Name) {
+		if keyspace.Scope == scopeNames[scopeIdx] {
+			names = append(names, keyspace.Collection)
+		}
+	}
+	return names
+}
+
+func (s *Scope) CreateCollections() {
+
+	vMajor, _ := strconv.ParseFloat(s.Version, 64)
+
+	operation := func(name string, server *ServerSpec) {
+
+		orchestrator := server.Names[0]
+		ip := s.Provider.GetHostAddress(orchestrator)
+
+		for _, bucket := range server.BucketSpecs {
+			if len(bucket.Scopes) == 0 {
+				continue
+			}
+			if vMajor > 0 && vMajor < 7.0 {
+				ecolorsay(fmt.Sprintf("bucket %s: scopes require version 7.0, skipping", bucket.Name))
+				continue
+			}
+
+			for _, bucketName := range bucket.Names {
+				for _, scopeName := range bucket.ScopeNames() {
+					command := []string{"collection-manage", "-c", ip,
+						"-u", server.RestUsername, "-p", server.RestPassword,
+						"--bucket", bucketName,
+						"--create-scope", scopeName,
+					}
+					s.RunCliTask("scope create "+bucketName+"."+scopeName, command, orchestrator)
+				}
+
+				for _, keyspace := range bucket.Keyspaces(bucketName) {
+					command := []string{"collection-manage", "-c", ip,
+						"-u", server.RestUsername, "-p", server.RestPassword,
+						"--bucket", bucketName,
+						"--create-collection", keyspace.Scope + "." + keyspace.Collection,
+					}
+					if keyspace.MaxTTL != nil {
+						command = append(command, "--max-ttl", strconv.Itoa(*keyspace.MaxTTL))
+					}
+					s.RunCliTask("collection create "+keyspace.String(), command, orchestrator)
+				}
+			}
+		}
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
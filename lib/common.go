@@ -15,6 +15,7 @@ import (
 	"path"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,11 +24,54 @@ func chkerr(err error) {
 		panic(err)
 	}
 }
+
+// fatal errors are sent to this channel instead of exiting
+// while set, allows a suite to abort only the running entry
+var fatalChan atomic.Value
+
+func SetFatalChan(ch chan interface{}) {
+	fatalChan.Store(ch)
+}
+
+func FatalChan() chan interface{} {
+	ch, _ := fatalChan.Load().(chan interface{})
+	return ch
+}
+
 func logerr(err error) {
 	if err != nil {
+		if FatalChan() != nil {
+			log.Println(err)
+			panic(err)
+		}
 		log.Fatalln(err)
 	}
 }
+
+// must be deferred by goroutines that may fail fatally,
+// sends the error to the fatal channel when one is set
+func RecoverFatal() {
+	ch := FatalChan()
+	if ch == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		select {
+		case ch <- r:
+		default:
+			// entry is already aborting
+		}
+	}
+}
+
+// run fn in a goroutine that recovers fatal errors
+func GoRecover(fn func()) {
+	go func() {
+		defer RecoverFatal()
+		fn()
+	}()
+}
+
 func logerrstr(err string) {
 	logerr(errors.New(err))
 }
@@ -40,7 +84,7 @@ func ecolorsay(msg string) {
 }
 
 func ExpandServerName(name string, count, offset uint8) []string {
-	if count <= 1 {
+	if count <= 1 && offset <= 1 {
 		parts := strings.Split(name, ".")
 		if len(parts) == 1 {
 			name = fmt.Sprintf("%s-1.st.couchbase.com", name)
@@ -176,6 +220,18 @@ func CommaStrToList(str string) []string {
 	return parts
 }
 
+// true if any non-empty item is in both lists
+func ListsIntersect(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x != "" && x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func PathToFilename(p string) string {
 	return path.Base(p)
 }
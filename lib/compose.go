@@ -0,0 +1,353 @@
+package sequoia
+
+/* Compose.go
+ *
+ * Composition of scope files.  A scope can extend a
+ * base scope and include other scope files, its own
+ * fields are merged over those of the bases.  List
+ * entries with a name, ie.. servers and buckets, are
+ * merged by name and other values are replaced.
+ *
+ * Vars of the top-level vars block and the scale factor
+ * are resolved in .Var and .Scale templates, ie..
+ *
+ *   extends: tests/simple/scope_base.yml
+ *   vars:
+ *     nodes: 4
+ *   servers:
+ *     - name: local
+ *       count: {{.Var `nodes`}}
+ *       init_nodes: {{.Var `nodes`}}
+ *
+ * Vars of a base are defaults for the scopes extending
+ * it, and -var flags take precedence over all files.
+ * Conditionals and pipelines of vars are rendered with
+ * the general template funcs, other actions are kept.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// keys of scope file handled before templating
+var ScopeFileKeys = []string{"extends", "include", "vars"}
+
+type ScopeFileHeader struct {
+	Extends interface{}
+	Include interface{}
+	Vars    map[string]interface{}
+}
+
+// resolves vars and scale in scope files
+type ScopeFileResolver struct {
+	Vars        map[string]string
+	ScaleFactor int
+}
+
+func (r *ScopeFileResolver) Var(name string) interface{} {
+	if val, ok := r.Vars[name]; ok == true {
+		return val
+	}
+	return "<var_not_found>"
+}
+
+func (r *ScopeFileResolver) Scale(val int) string {
+	scale := r.ScaleFactor
+	if scale == 0 {
+		scale++
+	}
+	return fmt.Sprintf("%d", val*scale)
+}
+
+// template actions and the fields, quoted strings
+// and identifiers within them
+var (
+	scopeActionRe      = regexp.MustCompile(`{{[\s\S]*?}}`)
+	scopeActionQuoteRe = regexp.MustCompile("\"(\\\\.|[^\"\\\\])*\"|`[^`]*`|'(\\\\.|[^'\\\\])*'")
+	scopeActionFieldRe = regexp.MustCompile(`(^|[^\w.$\)])\.([A-Za-z_]\w*)`)
+	scopeActionIdentRe = regexp.MustCompile(`(^|[^\w.$])([A-Za-z_]\w*)`)
+)
+
+// keywords and builtin functions of text/template
+var templateIdents = []string{
+	"if", "else", "end", "range", "with", "define", "template", "block",
+	"break", "continue", "nil", "true", "false",
+	"and", "or", "not", "len", "index", "slice", "print", "printf", "println",
+	"eq", "ne", "lt", "le", "gt", "ge", "call", "html", "js", "urlquery",
+}
+
+// true when action only uses fields of resolver and known
+// functions, others such as {{.User `name`}} are left for
+// test templates
+func (r *ScopeFileResolver) IsScopeAction(action string, funcs template.FuncMap) bool {
+	body := strings.TrimSuffix(strings.TrimPrefix(action, "{{"), "}}")
+	body = strings.TrimSpace(strings.Trim(body, "-"))
+	if strings.HasPrefix(body, "/*") {
+		// comment
+		return true
+	}
+	body = scopeActionQuoteRe.ReplaceAllString(body, `""`)
+
+	resolver := reflect.ValueOf(r)
+	for _, match := range scopeActionFieldRe.FindAllStringSubmatch(body, -1) {
+		name := match[2]
+		if resolver.MethodByName(name).IsValid() == false &&
+			resolver.Elem().FieldByName(name).IsValid() == false {
+			return false
+		}
+	}
+	for _, match := range scopeActionIdentRe.FindAllStringSubmatch(body, -1) {
+		name := match[2]
+		if _, ok := funcs[name]; ok == false && InList(name, templateIdents) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// renders vars and scale of scope file, actions that are
+// not for the scope are kept as is
+func (r *ScopeFileResolver) Render(fileName, source string) (string, error) {
+	funcs := (&TemplateResolver{}).GeneralFuncMap()
+	escaped := scopeActionRe.ReplaceAllStringFunc(source, func(action string) string {
+		if r.IsScopeAction(action, funcs) == true {
+			return action
+		}
+		// action prints itself
+		return "{{" + strconv.Quote(action) + "}}"
+	})
+
+	tmpl, err := template.New(fileName).Funcs(funcs).Parse(escaped)
+	if err != nil {
+		return source, err
+	}
+	out := new(bytes.Buffer)
+	if err = tmpl.Execute(out, r); err != nil {
+		return source, err
+	}
+	return out.String(), nil
+}
+
+// scope spec from composed yaml files
+func SpecFromComposedYaml(fileName string, vars map[string]string, scale int) ScopeSpec {
+
+	// file vars are overridden by provided vars
+	specVars := make(map[string]string)
+	logerr(CollectScopeVars(fileName, specVars, []string{}))
+	for k, v := range vars {
+		specVars[k] = v
+	}
+
+	resolver := ScopeFileResolver{specVars, scale}
+	composed, err := ComposeScopeFile(fileName, &resolver, []string{})
+	logerr(err)
+
+	var spec ScopeSpec
+	source, err := yaml.Marshal(composed)
+	chkerr(err)
+	DoUnmarshal(source, &spec)
+	colorsay("parsed " + fileName)
+
+	spec.Vars = specVars
+	ConfigureSpec(&spec)
+	return spec
+}
+
+// top-level blocks read without templating
+func ReadScopeFileHeader(fileName string) (ScopeFileHeader, []byte, error) {
+	var header ScopeFileHeader
+	source, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return header, nil, err
+	}
+	sections := []string{}
+	for _, key := range ScopeFileKeys {
+		sections = append(sections, string(YamlSection(source, key)))
+	}
+	err = yaml.Unmarshal([]byte(strings.Join(sections, "\n")), &header)
+	if err != nil {
+		return header, nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+	return header, source, nil
+}
+
+// lines of a top-level block of yaml source
+func YamlSection(source []byte, key string) []byte {
+	var section []string
+	for _, line := range strings.Split(string(source), "\n") {
+		if section == nil {
+			if strings.HasPrefix(line, key+":") {
+				section = []string{line}
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(line, " ") ||
+			strings.HasPrefix(line, "-") || strings.HasPrefix(line, "#") {
+			section = append(section, line)
+			continue
+		}
+		break
+	}
+	return []byte(strings.Join(section, "\n"))
+}
+
+// base files are those extended followed by those included
+func (h *ScopeFileHeader) BaseFiles(fileName string) []string {
+	files := []string{}
+	for _, val := range []interface{}{h.Extends, h.Include} {
+		names := []string{}
+		switch v := val.(type) {
+		case string:
+			names = CommaStrToList(v)
+		case []interface{}:
+			for _, item := range v {
+				names = append(names, fmt.Sprintf("%v", item))
+			}
+		}
+		for _, name := range names {
+			if name != "" {
+				files = append(files, ScopeFilePath(fileName, name))
+			}
+		}
+	}
+	return files
+}
+
+// base paths are relative to working directory
+// or else to directory of scope file
+func ScopeFilePath(fileName, base string) string {
+	if _, err := os.Stat(base); err == nil || filepath.IsAbs(base) {
+		return base
+	}
+	return filepath.Join(filepath.Dir(fileName), base)
+}
+
+func checkScopeCycle(fileName string, parents []string) error {
+	for _, parent := range parents {
+		if parent == fileName {
+			chain := append(parents, fileName)
+			return fmt.Errorf("scope includes itself: %s", strings.Join(chain, " -> "))
+		}
+	}
+	return nil
+}
+
+// vars of bases overridden by vars of file
+func CollectScopeVars(fileName string, vars map[string]string, parents []string) error {
+	if err := checkScopeCycle(fileName, parents); err != nil {
+		return err
+	}
+	header, _, err := ReadScopeFileHeader(fileName)
+	if err != nil {
+		return err
+	}
+	for _, base := range header.BaseFiles(fileName) {
+		err = CollectScopeVars(base, vars, append(parents, fileName))
+		if err != nil {
+			return err
+		}
+	}
+	for k, v := range header.Vars {
+		vars[k] = fmt.Sprintf("%v", v)
+	}
+	return nil
+}
+
+// renders file and its bases and merges them
+func ComposeScopeFile(fileName string, resolver *ScopeFileResolver, parents []string) (interface{}, error) {
+	if err := checkScopeCycle(fileName, parents); err != nil {
+		return nil, err
+	}
+	header, source, err := ReadScopeFileHeader(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var composed interface{} = map[interface{}]interface{}{}
+	for _, base := range header.BaseFiles(fileName) {
+		baseSpec, err := ComposeScopeFile(base, resolver, append(parents, fileName))
+		if err != nil {
+			return nil, err
+		}
+		composed = MergeYaml(composed, baseSpec)
+	}
+
+	rendered, err := resolver.Render(fileName, string(source))
+	if err != nil {
+		return nil, err
+	}
+
+	var spec map[interface{}]interface{}
+	if err = yaml.Unmarshal([]byte(rendered), &spec); err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+	for _, key := range ScopeFileKeys {
+		delete(spec, key)
+	}
+	return MergeYaml(composed, spec), nil
+}
+
+// merges over into base, maps are merged by key
+// and lists of named entries by name
+func MergeYaml(base, over interface{}) interface{} {
+	switch o := over.(type) {
+	case map[interface{}]interface{}:
+		b, ok := base.(map[interface{}]interface{})
+		if ok == false {
+			return o
+		}
+		merged := make(map[interface{}]interface{})
+		for k, v := range b {
+			merged[k] = v
+		}
+		for k, v := range o {
+			merged[k] = MergeYaml(b[k], v)
+		}
+		return merged
+	case []interface{}:
+		b, ok := base.([]interface{})
+		if ok == false || namedEntries(b) == false || namedEntries(o) == false {
+			return o
+		}
+		merged := append([]interface{}{}, b...)
+		for _, entry := range o {
+			name := entry.(map[interface{}]interface{})["name"]
+			found := false
+			for i, existing := range merged {
+				if existing.(map[interface{}]interface{})["name"] == name {
+					merged[i] = MergeYaml(existing, entry)
+					found = true
+				}
+			}
+			if found == false {
+				merged = append(merged, entry)
+			}
+		}
+		return merged
+	}
+	return over
+}
+
+func namedEntries(list []interface{}) bool {
+	for _, entry := range list {
+		m, ok := entry.(map[interface{}]interface{})
+		if ok == false {
+			return false
+		}
+		if _, ok = m["name"]; ok == false {
+			return false
+		}
+	}
+	return true
+}
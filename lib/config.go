@@ -0,0 +1,216 @@
+package sequoia
+
+/* Config.go
+ *
+ * Layered flag values.  Each flag takes its value from
+ * the first of these that sets it:
+ *
+ *   command line flag       -log_dir logs/run1
+ *   environment variable    SEQUOIA_LOG_DIR=logs/run1
+ *   config file             log_dir: logs/run1
+ *   flag default
+ *
+ * Config file keys and environment variables are named
+ * after flags.  Vars are merged by key across layers.
+ */
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+const CONFIG_ENV_PREFIX = "SEQUOIA_"
+
+const (
+	SOURCE_DEFAULT = "default"
+	SOURCE_CONFIG  = "config"
+	SOURCE_ENV     = "env"
+	SOURCE_FLAG    = "flag"
+	SOURCE_STATE   = "state"
+	SOURCE_MODE    = "mode"
+)
+
+// environment variable of flag, ie.. SEQUOIA_LOG_DIR
+func FlagEnvName(name string) string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(name)
+}
+
+// flag values of config file by flag name
+func ReadConfigFile(fileName string) (map[string]string, error) {
+	source, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	if err = yaml.Unmarshal(source, &config); err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+
+	values := make(map[string]string)
+	for key, val := range config {
+		if key == "vars" {
+			key = "var"
+		}
+		switch v := val.(type) {
+		case map[interface{}]interface{}:
+			// vars as map of key to value
+			items := []string{}
+			for k, item := range v {
+				items = append(items, fmt.Sprintf("%v=%v", k, item))
+			}
+			sort.Strings(items)
+			values[key] = strings.Join(items, ",")
+		case []interface{}:
+			items := []string{}
+			for _, item := range v {
+				items = append(items, fmt.Sprintf("%v", item))
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprintf("%v", v)
+		}
+	}
+	return values, nil
+}
+
+// sets comma separated key=value vars, a comma not
+// followed by another key= is part of the value
+func (v VarFlags) SetList(vars string) error {
+	entries := []string{}
+	for _, part := range strings.Split(vars, ",") {
+		if len(entries) > 0 && strings.Contains(part, "=") == false {
+			entries[len(entries)-1] += "," + part
+		} else if part != "" {
+			entries = append(entries, part)
+		}
+	}
+	for _, entry := range entries {
+		if err := v.Set(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applies config file and environment to flags
+// not set on command line and records source of
+// each flag value
+func (f *TestFlags) ApplyConfig(fset *flag.FlagSet) {
+
+	f.Sources = make(map[string]string)
+	explicit := make(map[string]bool)
+	fset.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = true
+	})
+
+	// config file can be given by environment
+	config := make(map[string]string)
+	if fl := fset.Lookup("config"); fl != nil {
+		if env, ok := os.LookupEnv(FlagEnvName(fl.Name)); ok == true && explicit[fl.Name] == false {
+			logerr(fl.Value.Set(env))
+		}
+		if configFile := fl.Value.String(); configFile != "" {
+			var err error
+			config, err = ReadConfigFile(configFile)
+			logerr(err)
+		}
+	}
+	for key, _ := range config {
+		if fset.Lookup(key) == nil {
+			ecolorsay(fmt.Sprintf("WARNING config key %s is not a flag of %s mode", key, fset.Name()))
+		}
+	}
+
+	fset.VisitAll(func(fl *flag.Flag) {
+		if vars, ok := fl.Value.(VarFlags); ok == true {
+			f.applyVarLayers(vars, config, explicit[fl.Name])
+			return
+		}
+
+		f.Sources[fl.Name] = SOURCE_DEFAULT
+		if explicit[fl.Name] == true {
+			f.Sources[fl.Name] = SOURCE_FLAG
+		} else if env, ok := os.LookupEnv(FlagEnvName(fl.Name)); ok == true {
+			logerr(fl.Value.Set(env))
+			f.Sources[fl.Name] = SOURCE_ENV
+		} else if val, ok := config[fl.Name]; ok == true {
+			logerr(fl.Value.Set(val))
+			f.Sources[fl.Name] = SOURCE_CONFIG
+		}
+	})
+}
+
+// vars of config then environment then command line,
+// sources are recorded per var, ie.. var.nodes
+func (f *TestFlags) applyVarLayers(vars VarFlags, config map[string]string, explicit bool) {
+
+	flagVars := make(VarFlags)
+	if explicit == true {
+		for k, v := range vars {
+			flagVars[k] = v
+		}
+	}
+
+	layers := []struct {
+		source string
+		vars   string
+	}{
+		{SOURCE_CONFIG, config["var"]},
+		{SOURCE_ENV, os.Getenv(FlagEnvName("var"))},
+	}
+	for _, layer := range layers {
+		layerVars := make(VarFlags)
+		logerr(layerVars.SetList(layer.vars))
+		for k, v := range layerVars {
+			vars[k] = v
+			f.Sources["var."+k] = layer.source
+		}
+	}
+	for k, v := range flagVars {
+		vars[k] = v
+		f.Sources["var."+k] = SOURCE_FLAG
+	}
+}
+
+// source of flag value, ie.. env SEQUOIA_LOG_DIR
+func (f *TestFlags) SourceOf(name string) string {
+	source, ok := f.Sources[name]
+	if ok == false {
+		return SOURCE_DEFAULT
+	}
+	switch source {
+	case SOURCE_ENV:
+		if strings.HasPrefix(name, "var.") {
+			return source + " " + FlagEnvName("var")
+		}
+		return source + " " + FlagEnvName(name)
+	case SOURCE_CONFIG:
+		return source + " " + *f.Config
+	case SOURCE_MODE:
+		return source + " " + f.Mode
+	}
+	return source
+}
+
+// config mode, prints effective flag values and their source
+func PrintConfig(flags TestFlags) {
+	fset := flags.FlagSet()
+	fmt.Printf("  %-20s %-40s %s\n", "FLAG", "VALUE", "SOURCE")
+	fset.VisitAll(func(fl *flag.Flag) {
+		if vars, ok := fl.Value.(VarFlags); ok == true {
+			for _, key := range vars.Keys() {
+				name := "var." + key
+				fmt.Printf("  %-20s %-40s %s\n", name, vars[key], flags.SourceOf(name))
+			}
+			return
+		}
+		fmt.Printf("  %-20s %-40s %s\n", fl.Name, fl.Value.String(), flags.SourceOf(fl.Name))
+	})
+}
@@ -325,9 +325,10 @@ func (cm *ContainerManager) KillContainer(id string) error {
 	return err
 }
 
+// removes containers created by any sequoia run
 func (cm *ContainerManager) RemoveAllContainers() {
 	// teardown
-	for _, c := range cm.GetAllContainers() {
+	for _, c := range cm.LabeledContainers("") {
 		err := cm.RemoveContainer(c.ID)
 		chkerr(err)
 		colorsay("remove " + c.Names[0])
@@ -338,7 +339,7 @@ func (cm *ContainerManager) RemoveAllServices() {
 	manager := cm.Client
 	// teardown services
 	for _, client := range cm.SwarmClients {
-		for _, svc := range cm.GetAllServices() {
+		for _, svc := range cm.LabeledServices("") {
 			cm.Client = client
 			err := cm.RemoveService(svc.ID)
 			chkerr(err)
@@ -480,6 +481,7 @@ func (cm *ContainerManager) PullImage(repo string) error {
 }
 
 func (cm *ContainerManager) pullImage(client *docker.Client, repo string, ch chan error) {
+	defer RecoverFatal()
 
 	imgOpts := docker.PullImageOptions{
 		Repository: repo,
@@ -555,6 +557,7 @@ func (cm *ContainerManager) BuildImage(opts docker.BuildImageOptions) error {
 }
 
 func (cm *ContainerManager) buildImage(client *docker.Client, opts docker.BuildImageOptions, ch chan error) {
+	defer RecoverFatal()
 	opts.OutputStream = os.Stdout
 	err := client.BuildImage(opts)
 	ch <- err
@@ -584,8 +587,18 @@ func (cm *ContainerManager) GetStatus(ID string) (string, error) {
 	return container.State.StateString(), nil
 }
 
+// get container exit code
+func (cm *ContainerManager) GetExitCode(ID string) (int, error) {
+	container, err := cm.Client.InspectContainer(ID)
+	if err != nil {
+		return 0, err
+	}
+	return container.State.ExitCode, nil
+}
+
 // logging to file or io
 func (cm *ContainerManager) LogContainer(ID string, output io.Writer, follow bool) {
+	defer RecoverFatal()
 
 	client := cm.ClientForContainer(ID)
 	logOpts := docker.LogsOptions{
@@ -602,6 +615,7 @@ func (cm *ContainerManager) LogContainer(ID string, output io.Writer, follow boo
 }
 
 func (cm *ContainerManager) WaitContainer(container *docker.Container, c chan TaskResult) {
+	defer RecoverFatal()
 
 	// get additional info about container
 	client := cm.ClientForContainer(container.ID)
@@ -651,11 +665,13 @@ func (cm *ContainerManager) StartContainer(id string, hostConfig *docker.HostCon
 
 
 func (cm *ContainerManager) CreateContainer(opts docker.CreateContainerOptions)  (*docker.Container, error) {
+       LabelContainerOptions(&opts)
        return cm.Client.CreateContainer(opts)
 }
 
 func (cm *ContainerManager) RunContainer(opts docker.CreateContainerOptions) (chan TaskResult, *docker.Container) {
 
+	LabelContainerOptions(&opts)
 	container, err := cm.Client.CreateContainer(opts)
 	logerr(err)
 
@@ -680,7 +696,31 @@ func (cm *ContainerManager) RunContainer(opts docker.CreateContainerOptions) (ch
 	return c, container
 }
 
+// reattach to a container started by a previous run
+func (cm *ContainerManager) ReattachContainer(id string) (chan error, error) {
+
+	client := cm.ClientForContainer(id)
+	container, err := client.InspectContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	if container.State.Running == false {
+		return nil, errors.New("container is not running")
+	}
+
+	c := make(chan TaskResult)
+	go cm.WaitContainer(container, c)
+
+	// save ID
+	cm.IDs = append(cm.IDs, container.ID)
+
+	echan := make(chan error, 1)
+	GoRecover(func() { cm.HandleResults(&[]chan TaskResult{c}, echan) })
+	return echan, nil
+}
+
 func (cm *ContainerManager) RunService(opts docker.CreateServiceOptions) *swarm.Service {
+	LabelServiceOptions(&opts)
 	service, err := cm.Client.CreateService(opts)
 	logerr(err)
 
@@ -863,7 +903,7 @@ func (cm *ContainerManager) Run(task *ContainerTask) (string, chan error) {
 	if task.Async == false {
 		cm.HandleResults(&idChans, echan)
 	} else {
-		go cm.HandleResults(&idChans, echan)
+		GoRecover(func() { cm.HandleResults(&idChans, echan) })
 	}
 
 	return container.ID, echan
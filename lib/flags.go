@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -35,12 +37,52 @@ type TestFlags struct {
 	LogLevel          *int
 	CleanLogs         *bool
 	CleanContainers   *bool
+	CleanRun          *string
+	OlderThan         *string
+	DryRun            *bool
 	Override          *string
+	OverrideFile      *string `yaml:"override_file"`
+	Vars              VarFlags
+	Sources           map[string]string
+	StateFile         *string
 	Exec              *bool
+	SuiteFile         *string
+	Only              *string
+	Skip              *string
 	DefaultFlagSet    *flag.FlagSet
 	ImageFlagSet      *flag.FlagSet
 	CleanFlagSet      *flag.FlagSet
 	TestrunnerFlagSet *flag.FlagSet
+	SuiteFlagSet      *flag.FlagSet
+}
+
+// template vars set by repeated -var key=value
+type VarFlags map[string]string
+
+func (v VarFlags) String() string {
+	vars := []string{}
+	for _, key := range v.Keys() {
+		vars = append(vars, key+"="+v[key])
+	}
+	return strings.Join(vars, ",")
+}
+
+func (v VarFlags) Set(val string) error {
+	parts := strings.SplitN(val, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid var %q, expected key=value", val)
+	}
+	v[parts[0]] = parts[1]
+	return nil
+}
+
+func (v VarFlags) Keys() []string {
+	keys := []string{}
+	for key, _ := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // parse top-level args and set test flag parsing mode
@@ -64,6 +106,16 @@ func NewTestFlags() TestFlags {
 	return f
 }
 
+// default mode flags parsed from provided args
+func NewTestFlagsFromArgs(args []string) TestFlags {
+	f := TestFlags{
+		Args: args,
+	}
+	f.SetFlagVals()
+	f.Parse()
+	return f
+}
+
 func (f *TestFlags) SetFlagVals() {
 	switch f.Mode {
 
@@ -73,7 +125,9 @@ func (f *TestFlags) SetFlagVals() {
 		f.AddDefaultFlags(f.ImageFlagSet)
 		f.AddImageFlags(f.ImageFlagSet)
 	case "clean":
-		f.ImageFlagSet = flag.NewFlagSet("clean", flag.ExitOnError)
+		// clean flagset
+		f.CleanFlagSet = flag.NewFlagSet("clean", flag.ExitOnError)
+		f.AddDefaultFlags(f.CleanFlagSet)
 		f.AddCleanFlags(f.CleanFlagSet)
 	case "testrunner":
 		// testrunner flagset
@@ -84,12 +138,39 @@ func (f *TestFlags) SetFlagVals() {
 		// include image flags and
 		f.AddImageFlags(f.TestrunnerFlagSet)
 
-		// override image flags for testrunner mode
+		// override image flags for testrunner mode,
+		// see TestrunnerModeFlags
 		*f.ImageName = "sequoiatools/testrunner"
 		*f.ImageWait = true
 		*f.LogLevel = 2
 		*f.SoftCleanup = true
 
+	case "resume":
+		// resume test using default flags
+		f.DefaultFlagSet = flag.NewFlagSet("resume", flag.ExitOnError)
+		f.AddDefaultFlags(f.DefaultFlagSet)
+	case "suite":
+		// suite flagset
+		f.SuiteFlagSet = flag.NewFlagSet("suite", flag.ExitOnError)
+		f.AddDefaultFlags(f.SuiteFlagSet)
+		f.AddSuiteFlags(f.SuiteFlagSet)
+	case "funcs":
+		// only lists template functions
+		f.DefaultFlagSet = flag.NewFlagSet("funcs", flag.ExitOnError)
+		f.AddDefaultFlags(f.DefaultFlagSet)
+	case "placement":
+		// only prints node placement of scope
+		f.DefaultFlagSet = flag.NewFlagSet("placement", flag.ExitOnError)
+		f.AddDefaultFlags(f.DefaultFlagSet)
+	case "teardown":
+		// undo scope setup on existing cluster
+		f.DefaultFlagSet = flag.NewFlagSet("teardown", flag.ExitOnError)
+		f.AddDefaultFlags(f.DefaultFlagSet)
+	case "config":
+		// only prints flag values and their source
+		f.DefaultFlagSet = flag.NewFlagSet("config", flag.ExitOnError)
+		f.AddDefaultFlags(f.DefaultFlagSet)
+
 	default:
 		// default cli flags
 		f.DefaultFlagSet = flag.NewFlagSet("default", flag.ExitOnError)
@@ -98,12 +179,36 @@ func (f *TestFlags) SetFlagVals() {
 	}
 }
 
+// flags whose defaults are overridden in testrunner mode
+var TestrunnerModeFlags = []string{"name", "wait", "log_level", "soft_cleanup"}
+
 func (f *TestFlags) Parse() {
 	switch f.Mode {
 	case "image":
 		f.ImageFlagSet.Parse(f.Args[1:])
+	case "clean":
+		f.CleanFlagSet.Parse(f.Args[1:])
 	case "testrunner":
 		f.TestrunnerFlagSet.Parse(f.Args[1:])
+	case "resume", "funcs", "placement", "teardown", "config":
+		f.DefaultFlagSet.Parse(f.Args[1:])
+	case "suite":
+		f.SuiteFlagSet.Parse(f.Args[1:])
+	default:
+		f.DefaultFlagSet.Parse(f.Args)
+	}
+
+	// flags not on command line are set
+	// from environment or config file
+	f.ApplyConfig(f.FlagSet())
+
+	if f.Mode == "testrunner" {
+		// defaults overridden by mode
+		for _, name := range TestrunnerModeFlags {
+			if f.Sources[name] == SOURCE_DEFAULT {
+				f.Sources[name] = SOURCE_MODE
+			}
+		}
 
 		// override scope with ini file
 		flagArgs := strings.Split(*f.ImageCommand, " ")
@@ -113,17 +218,48 @@ func (f *TestFlags) Parse() {
 				if len(flagArgs) >= argOffset {
 					iniFile := flagArgs[argOffset]
 					*f.ScopeFile = fmt.Sprintf("%s/%s", "containers/testrunner/src", iniFile)
+					f.Sources["scope"] = SOURCE_MODE
 				}
 			}
 		}
-	default:
-		f.DefaultFlagSet.Parse(f.Args)
 	}
 
-	if *f.Config != "" {
-		// set flags to config vars
-		ReadYamlFile(*f.Config, f)
+	if f.Mode == "resume" {
+		// continue saved test against existing cluster
+		state := LoadRunState(f.RunStateFile())
+		*f.ScopeFile = state.Scope
+		*f.TestFile = state.Test
+		*f.SkipSetup = true
+		if state.Run != "" {
+			RunID = state.Run
+		}
+		f.Sources["scope"] = SOURCE_STATE
+		f.Sources["test"] = SOURCE_STATE
+		f.Sources["skip_setup"] = SOURCE_STATE
+	}
+}
+
+// flagset of mode
+func (f *TestFlags) FlagSet() *flag.FlagSet {
+	switch f.Mode {
+	case "image":
+		return f.ImageFlagSet
+	case "clean":
+		return f.CleanFlagSet
+	case "testrunner":
+		return f.TestrunnerFlagSet
+	case "suite":
+		return f.SuiteFlagSet
 	}
+	return f.DefaultFlagSet
+}
+
+// file where test progress is saved
+func (f *TestFlags) RunStateFile() string {
+	if *f.StateFile != "" {
+		return *f.StateFile
+	}
+	return filepath.Join(*f.LogDir, "run_state.yml")
 }
 
 func (f *TestFlags) AddDefaultFlags(fset *flag.FlagSet) {
@@ -210,7 +346,17 @@ func (f *TestFlags) AddDefaultFlags(fset *flag.FlagSet) {
 		"name container created from image")
 	f.Override = fset.String(
 		"override", "",
-		"override params, ie servers:local.count=1,servers:remote.count=1")
+		"override params, ie servers:local.count=1,buckets:default.replica=2,provider:build=5.0.0")
+	f.OverrideFile = fset.String(
+		"override_file", "",
+		"yaml patch of overrides, ie servers: {local: {count: 1}}")
+	f.Vars = make(VarFlags)
+	fset.Var(
+		f.Vars, "var",
+		"template var of scope and test files, ie nodes=4 (repeatable)")
+	f.StateFile = fset.String(
+		"state", "",
+		"run state file used to resume test (default <log_dir>/run_state.yml)")
 }
 
 func (f *TestFlags) AddImageFlags(fset *flag.FlagSet) {
@@ -226,14 +372,52 @@ func (f *TestFlags) AddImageFlags(fset *flag.FlagSet) {
 func (f *TestFlags) AddCleanFlags(fset *flag.FlagSet) {
 	f.CleanLogs = fset.Bool(
 		"logs", true,
-		"remove all logs")
+		"remove logs")
 	f.CleanContainers = fset.Bool(
 		"containers", true,
-		"remove all containers")
-	f.LogDir = fset.String(
-		"log_dir",
-		"logs",
-		"directory of log files")
+		"remove containers and services created by sequoia")
+	f.CleanRun = fset.String(
+		"run", "",
+		"only remove what was created by run id (default all runs)")
+	f.OlderThan = fset.String(
+		"older_than", "",
+		"only remove what was created before duration ago, ie 24h")
+	f.DryRun = fset.Bool(
+		"dry_run", false,
+		"list what would be removed without removing")
+}
+
+func (f *TestFlags) AddSuiteFlags(fset *flag.FlagSet) {
+	f.SuiteFile = fset.String(
+		"suite", "tests/simple/suite.yml",
+		"suite file of scope, test and flags entries")
+	f.Only = fset.String(
+		"only", "",
+		"comma separated names of suite entries to run")
+	f.Skip = fset.String(
+		"skip", "",
+		"comma separated names of suite entries to skip")
+}
+
+// args for flags explicitly set in flagset
+func (f *TestFlags) SetArgs(fset *flag.FlagSet, exclude ...string) []string {
+	args := []string{}
+	fset.Visit(func(fl *flag.Flag) {
+		for _, name := range exclude {
+			if fl.Name == name {
+				return
+			}
+		}
+		if vars, ok := fl.Value.(VarFlags); ok == true {
+			// each var is its own flag
+			for _, key := range vars.Keys() {
+				args = append(args, fmt.Sprintf("-%s=%s=%s", fl.Name, key, vars[key]))
+			}
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", fl.Name, fl.Value.String()))
+	})
+	return args
 }
 
 func (f *TestFlags) AddTestrunnerFlags(fset *flag.FlagSet) {
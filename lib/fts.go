@@ -0,0 +1,219 @@
+package sequoia
+
+/* Fts.go
+ *
+ * Creates full text indexes declared in scope spec
+ * through the fts rest api and waits until ready
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const FTS_READY_TIMEOUT = 10 * time.Minute
+
+// index definition from file or inline definition, with
+// name, source bucket, params and plan params applied
+func (f *FtsIndexSpec) IndexDefinition() (map[string]interface{}, error) {
+
+	var def interface{}
+	if f.File != "" {
+		source, err := ioutil.ReadFile(f.File)
+		if err != nil {
+			return nil, err
+		}
+		// json definition as exported from ui, else yaml
+		if err = json.Unmarshal(source, &def); err != nil {
+			DoUnmarshal(source, &def)
+		}
+	} else if f.Definition != nil {
+		def = f.Definition
+	}
+
+	index, ok := ToJsonValue(def).(map[string]interface{})
+	if ok == false {
+		if def != nil {
+			return nil, fmt.Errorf("fts index %s: definition must be an object", f.Name)
+		}
+		index = make(map[string]interface{})
+	}
+
+	index["name"] = f.Name
+	index["sourceName"] = f.Bucket
+	if _, ok := index["type"]; ok == false {
+		index["type"] = "fulltext-index"
+	}
+	if _, ok := index["sourceType"]; ok == false {
+		index["sourceType"] = "couchbase"
+	}
+	if f.Params != nil {
+		index["params"] = ToJsonValue(f.Params)
+	}
+
+	if f.Partitions > 0 || f.Replicas > 0 {
+		plan, ok := index["planParams"].(map[string]interface{})
+		if ok == false {
+			plan = make(map[string]interface{})
+		}
+		if f.Partitions > 0 {
+			plan["indexPartitions"] = f.Partitions
+		}
+		if f.Replicas > 0 {
+			plan["numReplicas"] = f.Replicas
+		}
+		index["planParams"] = plan
+	}
+	return index, nil
+}
+
+// converts decoded yaml maps to json compatible maps
+func ToJsonValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for key, item := range val {
+			m[fmt.Sprintf("%v", key)] = ToJsonValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{})
+		for key, item := range val {
+			m[key] = ToJsonValue(item)
+		}
+		return m
+	case []interface{}:
+		items := []interface{}{}
+		for _, item := range val {
+			items = append(items, ToJsonValue(item))
+		}
+		return items
+	}
+	return v
+}
+
+func (s *Scope) CreateFtsIndexes() {
+
+	operation := func(name string, server *ServerSpec) {
+
+		indexes := s.ClusterFtsIndexes(server)
+		if len(indexes) == 0 {
+			return
+		}
+
+		ftsNode, ok := s.ServiceNode(server, "fts")
+		if ok == false {
+			ecolorsay("cannot create fts indexes without fts node in cluster " + server.Name)
+			return
+		}
+		ftsHost := FtsHost(s.Provider.GetHostAddress(ftsNode))
+
+		created := []FtsIndexSpec{}
+		for _, index := range indexes {
+			def, err := index.IndexDefinition()
+			if err == nil {
+				defJson, _ := json.Marshal(def)
+				err = PutFtsIndex(ftsHost, server.RestUsername, server.RestPassword, index.Name, defJson)
+			}
+			desc := "fts index create " + index.Name
+			if err != nil {
+				msg := UtilTaskMsg("[fts]", fmt.Sprintf("%s: %s", desc, err))
+				ecolorsay(msg)
+				s.Cm.TapHandle.Ok(false, msg)
+				continue
+			}
+			colorsay(desc)
+			created = append(created, index)
+		}
+
+		s.WaitForFtsIndexes(server, ftsHost, created)
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
+
+// source bucket of fts index, either an expanded bucket
+// name or the name of a bucket spec as for gsi indexes
+func (s *ScopeSpec) FtsIndexBucket(index FtsIndexSpec) (string, string, error) {
+	for _, server := range s.Servers {
+		for _, bucket := range server.BucketSpecs {
+			if InList(index.Bucket, bucket.Names) {
+				return server.Name, index.Bucket, nil
+			}
+			if bucket.Name != index.Bucket {
+				continue
+			}
+			if len(bucket.Names) != 1 {
+				return "", "", fmt.Errorf("fts index %s: bucket %s expands to %s, use one of these",
+					index.Name, bucket.Name, strings.Join(bucket.Names, ","))
+			}
+			return server.Name, bucket.Names[0], nil
+		}
+	}
+	return "", "", fmt.Errorf("fts index %s: unknown bucket %s", index.Name, index.Bucket)
+}
+
+// fts indexes on buckets of cluster with
+// source bucket resolved to bucket name
+func (s *Scope) ClusterFtsIndexes(server *ServerSpec) []FtsIndexSpec {
+	indexes := []FtsIndexSpec{}
+	for _, index := range s.Spec.FtsIndexes {
+		cluster, bucketName, err := s.Spec.FtsIndexBucket(index)
+		if err != nil || cluster != server.Name {
+			continue
+		}
+		index.Bucket = bucketName
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
+
+// blocks until all partitions of each index are active
+func (s *Scope) WaitForFtsIndexes(server *ServerSpec, ftsHost string, indexes []FtsIndexSpec) {
+
+	start := time.Now()
+	for {
+		pending := []string{}
+		for _, index := range indexes {
+			stats, err := GetFtsIndexStats(ftsHost, server.RestUsername, server.RestPassword, index.Name)
+			if err != nil || FtsIndexIsReady(stats) == false {
+				pending = append(pending, index.Name)
+			}
+		}
+
+		if len(pending) == 0 {
+			colorsay("fts indexes ready")
+			return
+		}
+		if time.Since(start) > FTS_READY_TIMEOUT {
+			msg := UtilTaskMsg("[fts]", fmt.Sprintf("timed out waiting for fts indexes of %s: %s",
+				server.Name, strings.Join(pending, ",")))
+			ecolorsay(msg)
+			s.Cm.TapHandle.Ok(false, msg)
+			return
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// true when actual partitions match target partitions
+func FtsIndexIsReady(stats map[string]interface{}) bool {
+	var actual, target float64
+	for key, val := range stats {
+		n, ok := val.(float64)
+		if ok == false {
+			continue
+		}
+		if strings.HasSuffix(key, "num_pindexes_actual") {
+			actual += n
+		}
+		if strings.HasSuffix(key, "num_pindexes_target") {
+			target += n
+		}
+	}
+	return target > 0 && actual >= target
+}
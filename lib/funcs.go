@@ -0,0 +1,314 @@
+package sequoia
+
+/* Funcs.go
+ *
+ * General purpose template functions for arithmetic,
+ * strings, random values, json and environment that
+ * are available to test yaml, ie..
+ *   {{.Loop | add 1}}
+ *   {{.NodeNames .ClusterNodes | join ","}}
+ */
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type FuncDoc struct {
+	Name    string
+	Usage   string
+	Example string
+}
+
+// documentation of template functions shown by 'funcs' mode
+var TemplateFuncDocs = []FuncDoc{
+	{"net", "net <index> <servers>", "{{.Nodes | .Service `n1ql` | net 0}}"},
+	{"bucket", "bucket <index> <servers>", "{{.ClusterNodes | bucket 0}}"},
+	{"noport", "noport <addr>", "{{.Orchestrator | noport}}"},
+	{"json", "json <string>", "{{(.TailLogs `stats` 1 | json).ops}}"},
+	{"ftoint", "ftoint <float>", "{{ftoint 2.5}}"},
+	{"last", "last <list>", "{{last $sample.op.samples.ops}}"},
+	{"contains", "contains <key> <string>", "{{contains `loader` `done`}}"},
+	{"excludes", "excludes <key> <string>", "{{excludes `loader` `error`}}"},
+	{"tolist", "tolist <server>", "{{tolist $server}}"},
+	{"strlist", "strlist <items...>", "{{range strlist `a` `b`}}{{.}}{{end}}"},
+	{"mkrange", "mkrange <start> <end> [step]", "{{range mkrange 1 10 2}}{{.}}{{end}}"},
+	{"to_ip", "to_ip <name>", "{{to_ip `cb-1`}}"},
+	{"add", "add <a> <b>", "{{.Loop | add 1}}"},
+	{"sub", "sub <a> <b>", "{{sub 10 .Loop}}"},
+	{"mul", "mul <a> <b>", "{{.Scale 10 | mul 2}}"},
+	{"div", "div <a> <b>", "{{div 10 4}}"},
+	{"mod", "mod <a> <b>", "{{mod .Loop 2}}"},
+	{"printf", "printf <format> <args...>", "{{printf `%s:%d` .Orchestrator 8091}}"},
+	{"join", "join <sep> <list>", "{{.NodeNames .ClusterNodes | join `,`}}"},
+	{"split", "split <sep> <string>", "{{`a,b` | split `,`}}"},
+	{"upper", "upper <string>", "{{.Bucket | upper}}"},
+	{"lower", "lower <string>", "{{.Bucket | lower}}"},
+	{"randInt", "randInt <min> <max>", "{{randInt 0 100}}"},
+	{"randChoice", "randChoice <list>", "{{.NodeNames .ClusterNodes | randChoice}}"},
+	{"shuffle", "shuffle <list>", "{{range shuffle (strlist `a` `b` `c`)}}{{.}}{{end}}"},
+	{"sort", "sort <list>", "{{.NodeNames .ClusterNodes | sort | join `,`}}"},
+	{"default", "default <default> <value>", "{{.Var `count` | default 0}}"},
+	{"jsonpath", "jsonpath <path> <json>", "{{.TailLogs `stats` 1 | jsonpath `op.samples.ops.0`}}"},
+	{"now", "now [layout]", "{{now `2006-01-02`}}"},
+	{"duration", "duration <string>", "{{duration `5m`}}"},
+	{"env", "env <name>", "{{env `HOME`}}"},
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// general purpose functions added to resolver FuncMap
+func (t *TemplateResolver) GeneralFuncMap() template.FuncMap {
+	return template.FuncMap{
+		"add":        Add,
+		"sub":        Sub,
+		"mul":        Mul,
+		"div":        Div,
+		"mod":        Mod,
+		"printf":     fmt.Sprintf,
+		"join":       Join,
+		"split":      Split,
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"randInt":    RandInt,
+		"randChoice": RandChoice,
+		"shuffle":    Shuffle,
+		"sort":       SortList,
+		"default":    Default,
+		"jsonpath":   JsonPath,
+		"now":        Now,
+		"duration":   Duration,
+		"env":        os.Getenv,
+	}
+}
+
+// print documented template functions
+func PrintTemplateFuncs() {
+	for _, doc := range TemplateFuncDocs {
+		fmt.Printf("%-12s %-30s %s\n", doc.Name, doc.Usage, doc.Example)
+	}
+}
+
+// converts template value to number, returning
+// whether the value is integral
+func ToNumber(v interface{}) (float64, bool, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true, nil
+	case int32:
+		return float64(n), true, nil
+	case int64:
+		return float64(n), true, nil
+	case float32:
+		return float64(n), false, nil
+	case float64:
+		return n, false, nil
+	case string:
+		if i, err := strconv.Atoi(strings.TrimSpace(n)); err == nil {
+			return float64(i), true, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("not a number: %q", n)
+		}
+		return f, false, nil
+	}
+	return 0, false, fmt.Errorf("not a number: %v", v)
+}
+
+// applies op to both operands, result is int
+// when both operands are integral
+func arith(a, b interface{}, op func(x, y float64) float64) (interface{}, error) {
+	x, xInt, err := ToNumber(a)
+	if err != nil {
+		return nil, err
+	}
+	y, yInt, err := ToNumber(b)
+	if err != nil {
+		return nil, err
+	}
+	val := op(x, y)
+	if xInt && yInt {
+		return int(val), nil
+	}
+	return val, nil
+}
+
+func Add(a, b interface{}) (interface{}, error) {
+	return arith(a, b, func(x, y float64) float64 { return x + y })
+}
+
+func Sub(a, b interface{}) (interface{}, error) {
+	return arith(a, b, func(x, y float64) float64 { return x - y })
+}
+
+func Mul(a, b interface{}) (interface{}, error) {
+	return arith(a, b, func(x, y float64) float64 { return x * y })
+}
+
+// integer division when both operands are integral
+func Div(a, b interface{}) (interface{}, error) {
+	if y, _, err := ToNumber(b); err == nil && y == 0 {
+		return nil, errors.New("division by zero")
+	}
+	return arith(a, b, func(x, y float64) float64 { return x / y })
+}
+
+func Mod(a, b interface{}) (interface{}, error) {
+	if y, _, err := ToNumber(b); err == nil && y == 0 {
+		return nil, errors.New("division by zero")
+	}
+	return arith(a, b, math.Mod)
+}
+
+// converts slice of any type to []interface{}
+func ToInterfaceList(list interface{}) ([]interface{}, error) {
+	items := []interface{}{}
+	val := reflect.ValueOf(list)
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return items, fmt.Errorf("not a list: %v", list)
+	}
+	for i := 0; i < val.Len(); i++ {
+		items = append(items, val.Index(i).Interface())
+	}
+	return items, nil
+}
+
+func Join(sep string, list interface{}) (string, error) {
+	items, err := ToInterfaceList(list)
+	if err != nil {
+		return "", err
+	}
+	parts := []string{}
+	for _, item := range items {
+		parts = append(parts, fmt.Sprintf("%v", item))
+	}
+	return strings.Join(parts, sep), nil
+}
+
+func Split(sep, str string) []string {
+	return strings.Split(str, sep)
+}
+
+// random int in range [min, max)
+func RandInt(min, max int) (int, error) {
+	if max <= min {
+		return 0, fmt.Errorf("randInt max %d must be greater than min %d", max, min)
+	}
+	return min + rand.Intn(max-min), nil
+}
+
+func RandChoice(list interface{}) (interface{}, error) {
+	items, err := ToInterfaceList(list)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("randChoice of empty list")
+	}
+	return items[rand.Intn(len(items))], nil
+}
+
+func Shuffle(list interface{}) ([]interface{}, error) {
+	items, err := ToInterfaceList(list)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(items) - 1; i > 0; i-- {
+		j := rand.Intn(i + 1)
+		items[i], items[j] = items[j], items[i]
+	}
+	return items, nil
+}
+
+// sorts numerically when all items are numbers
+func SortList(list interface{}) ([]interface{}, error) {
+	items, err := ToInterfaceList(list)
+	if err != nil {
+		return nil, err
+	}
+	numeric := true
+	for _, item := range items {
+		if _, _, err := ToNumber(item); err != nil {
+			numeric = false
+			break
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if numeric == true {
+			x, _, _ := ToNumber(items[i])
+			y, _, _ := ToNumber(items[j])
+			return x < y
+		}
+		return fmt.Sprintf("%v", items[i]) < fmt.Sprintf("%v", items[j])
+	})
+	return items, nil
+}
+
+// value unless it is empty or an unknown var
+func Default(def, val interface{}) interface{} {
+	if val == nil {
+		return def
+	}
+	switch v := val.(type) {
+	case string:
+		if v == "" || v == "<var_not_found>" {
+			return def
+		}
+	case bool:
+		if v == false {
+			return def
+		}
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		if rv.Len() == 0 {
+			return def
+		}
+	}
+	return val
+}
+
+// lookup dot separated path in json string or decoded json
+func JsonPath(path string, data interface{}) (interface{}, error) {
+	if str, ok := data.(string); ok {
+		parsed, err := ParseJsonOutput(str)
+		if err != nil {
+			return nil, err
+		}
+		data = parsed
+	}
+	return JsonPathLookup(data, path)
+}
+
+// current time formatted by optional layout, RFC3339 by default
+func Now(layout ...string) string {
+	if len(layout) > 0 {
+		if layout[0] == "unix" {
+			return strconv.FormatInt(time.Now().Unix(), 10)
+		}
+		return time.Now().Format(layout[0])
+	}
+	return time.Now().Format(time.RFC3339)
+}
+
+// duration string as seconds, ie.. 5m = 300
+func Duration(str string) (int, error) {
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, err
+	}
+	return int(d.Seconds()), nil
+}
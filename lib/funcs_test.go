@@ -0,0 +1,363 @@
+package sequoia
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestArithFuncs(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(a, b interface{}) (interface{}, error)
+		a, b interface{}
+		want interface{}
+		err  bool
+	}{
+		{"add ints", Add, 1, 2, 3, false},
+		{"add float", Add, 1, 2.5, 3.5, false},
+		{"add string int", Add, "3", 4, 7, false},
+		{"add not a number", Add, "x", 1, nil, true},
+		{"sub", Sub, 10, 4, 6, false},
+		{"sub negative", Sub, 1, 2.5, -1.5, false},
+		{"mul", Mul, "3", 4, 12, false},
+		{"mul float", Mul, 1.5, 2, 3.0, false},
+		{"div integral", Div, 10, 4, 2, false},
+		{"div float", Div, 10.0, 4, 2.5, false},
+		{"div by zero", Div, 1, 0, nil, true},
+		{"div by zero float", Div, 1, 0.0, nil, true},
+		{"div by zero string", Div, 1, "0", nil, true},
+		{"mod", Mod, 7, 3, 1, false},
+		{"mod float", Mod, 7.5, 2, 1.5, false},
+		{"mod by zero", Mod, 7, 0, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.fn(tt.a, tt.b)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v, want error %t", tt.name, err, tt.err)
+			continue
+		}
+		if tt.err == false && reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%s: got %v (%T), want %v (%T)", tt.name, got, got, tt.want, tt.want)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		sep  string
+		list interface{}
+		want string
+		err  bool
+	}{
+		{",", []string{"a", "b", "c"}, "a,b,c", false},
+		{"|", []int{1, 2}, "1|2", false},
+		{",", []string{}, "", false},
+		{",", "a,b", "", true},
+	}
+	for _, tt := range tests {
+		got, err := Join(tt.sep, tt.list)
+		if (err != nil) != tt.err {
+			t.Errorf("join %q %v: error %v, want error %t", tt.sep, tt.list, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("join %q %v: got %q, want %q", tt.sep, tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		sep, str string
+		want     []string
+	}{
+		{",", "a,b,c", []string{"a", "b", "c"}},
+		{",", "a", []string{"a"}},
+		{",", "", []string{""}},
+		{"::", "a::b", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		got := Split(tt.sep, tt.str)
+		if reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("split %q %q: got %q, want %q", tt.sep, tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestRandInt(t *testing.T) {
+	tests := []struct {
+		min, max int
+		err      bool
+	}{
+		{0, 1, false},
+		{0, 10, false},
+		{-5, 5, false},
+		{5, 5, true},
+		{10, 0, true},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			got, err := RandInt(tt.min, tt.max)
+			if (err != nil) != tt.err {
+				t.Fatalf("randInt %d %d: error %v, want error %t", tt.min, tt.max, err, tt.err)
+			}
+			if tt.err == false && (got < tt.min || got >= tt.max) {
+				t.Fatalf("randInt %d %d: got %d out of range", tt.min, tt.max, got)
+			}
+		}
+	}
+}
+
+func TestRandChoice(t *testing.T) {
+	tests := []struct {
+		list interface{}
+		err  bool
+	}{
+		{[]string{"a", "b"}, false},
+		{[]int{1}, false},
+		{[]string{}, true},
+		{[]interface{}{}, true},
+		{"a", true},
+	}
+	for _, tt := range tests {
+		got, err := RandChoice(tt.list)
+		if (err != nil) != tt.err {
+			t.Errorf("randChoice %v: error %v, want error %t", tt.list, err, tt.err)
+			continue
+		}
+		if tt.err == false {
+			items, _ := ToInterfaceList(tt.list)
+			found := false
+			for _, item := range items {
+				if item == got {
+					found = true
+				}
+			}
+			if found == false {
+				t.Errorf("randChoice %v: got %v not in list", tt.list, got)
+			}
+		}
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	tests := []struct {
+		list interface{}
+		want []interface{}
+		err  bool
+	}{
+		{[]string{}, []interface{}{}, false},
+		{[]int{1}, []interface{}{1}, false},
+		{[]int{3, 1, 2}, []interface{}{1, 2, 3}, false},
+		{"a", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := Shuffle(tt.list)
+		if (err != nil) != tt.err {
+			t.Errorf("shuffle %v: error %v, want error %t", tt.list, err, tt.err)
+			continue
+		}
+		if tt.err == true {
+			continue
+		}
+		// shuffled items are a permutation of list
+		sorted, _ := SortList(got)
+		if reflect.DeepEqual(sorted, tt.want) == false {
+			t.Errorf("shuffle %v: got %v", tt.list, got)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	tests := []struct {
+		def, val interface{}
+		want     interface{}
+	}{
+		{0, nil, 0},
+		{0, "", 0},
+		{0, "<var_not_found>", 0},
+		{0, "5", "5"},
+		{true, false, true},
+		{"x", []string{}, "x"},
+		{"x", map[string]int{}, "x"},
+		{"x", []string{"a"}, []string{"a"}},
+		{1, 0, 0},
+	}
+	for _, tt := range tests {
+		got := Default(tt.def, tt.val)
+		if reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("default %v %v: got %v, want %v", tt.def, tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestJsonPath(t *testing.T) {
+	doc := `{"op": {"samples": {"ops": [10, 20]}, "name": "load"}}`
+	tests := []struct {
+		path string
+		data interface{}
+		want interface{}
+		err  bool
+	}{
+		{"op.name", doc, "load", false},
+		{"op.samples.ops.1", doc, 20.0, false},
+		{"op.missing", doc, nil, true},
+		{"op.samples.ops.2", doc, nil, true},
+		{"op.name.first", doc, nil, true},
+		{"a", "not json", nil, true},
+		{"a", map[string]interface{}{"a": 1}, 1, false},
+	}
+	for _, tt := range tests {
+		got, err := JsonPath(tt.path, tt.data)
+		if (err != nil) != tt.err {
+			t.Errorf("jsonpath %q: error %v, want error %t", tt.path, err, tt.err)
+			continue
+		}
+		if tt.err == false && reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("jsonpath %q: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		str  string
+		want int
+		err  bool
+	}{
+		{"5m", 300, false},
+		{"1h30m", 5400, false},
+		{"90s", 90, false},
+		{"500ms", 0, false},
+		{"5", 0, true},
+		{"five minutes", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := Duration(tt.str)
+		if (err != nil) != tt.err {
+			t.Errorf("duration %q: error %v, want error %t", tt.str, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("duration %q: got %d, want %d", tt.str, got, tt.want)
+		}
+	}
+}
+
+// renders text with the general template funcs
+func renderFuncs(t *testing.T, text string) string {
+	tmpl, err := template.New("funcs").
+		Funcs((&TemplateResolver{}).GeneralFuncMap()).
+		Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if err = tmpl.Execute(out, nil); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestStringFuncs(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"{{printf `%s:%d` `host` 8091}}", "host:8091"},
+		{"{{printf `%v` (add 1 2)}}", "3"},
+		{"{{upper `bucket`}}", "BUCKET"},
+		{"{{`Bucket-1` | lower}}", "bucket-1"},
+		{"{{split `,` `b,a` | sort | join `,` | upper}}", "A,B"},
+	}
+	for _, tt := range tests {
+		if got := renderFuncs(t, tt.text); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSortList(t *testing.T) {
+	tests := []struct {
+		list interface{}
+		want []interface{}
+		err  bool
+	}{
+		{[]string{"c", "a", "b"}, []interface{}{"a", "b", "c"}, false},
+		{[]int{10, 2, 1}, []interface{}{1, 2, 10}, false},
+		{[]string{"10", "9", "1"}, []interface{}{"1", "9", "10"}, false},
+		{[]interface{}{"b", 1, "a"}, []interface{}{1, "a", "b"}, false},
+		{[]string{}, []interface{}{}, false},
+		{"a", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := SortList(tt.list)
+		if (err != nil) != tt.err {
+			t.Errorf("sort %v: error %v, want error %t", tt.list, err, tt.err)
+			continue
+		}
+		if tt.err == false && reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("sort %v: got %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestNow(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+	tests := []struct {
+		layout []string
+		parse  func(string) (time.Time, error)
+	}{
+		{nil, func(s string) (time.Time, error) {
+			return time.Parse(time.RFC3339, s)
+		}},
+		{[]string{"2006-01-02 15:04:05"}, func(s string) (time.Time, error) {
+			return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+		}},
+		{[]string{"unix"}, func(s string) (time.Time, error) {
+			sec, err := strconv.ParseInt(s, 10, 64)
+			return time.Unix(sec, 0), err
+		}},
+	}
+	for _, tt := range tests {
+		got := Now(tt.layout...)
+		parsed, err := tt.parse(got)
+		if err != nil {
+			t.Errorf("now %v: got %q, %v", tt.layout, got, err)
+			continue
+		}
+		if parsed.Before(before) || parsed.After(time.Now()) {
+			t.Errorf("now %v: got %q, not current time", tt.layout, got)
+		}
+	}
+}
+
+func TestEnvFunc(t *testing.T) {
+	const name = "SEQUOIA_FUNCS_TEST_VAR"
+	os.Unsetenv(name)
+	defer os.Unsetenv(name)
+
+	tests := []struct {
+		set  bool
+		text string
+		want string
+	}{
+		{false, "{{env `" + name + "`}}", ""},
+		{false, "{{env `" + name + "` | default `none`}}", "none"},
+		{true, "{{env `" + name + "`}}", "value"},
+	}
+	for _, tt := range tests {
+		if tt.set == true {
+			os.Setenv(name, "value")
+		}
+		if got := renderFuncs(t, tt.text); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
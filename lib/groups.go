@@ -0,0 +1,174 @@
+package sequoia
+
+/* Groups.go
+ *
+ * Assigns nodes of server spec to server groups
+ * and creates the groups during setup so that
+ * nodes are added directly into their group
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const DEFAULT_SERVER_GROUP = "Group 1"
+
+// sorted group names of server spec including
+// groups only referenced by explicit nodes
+func (s *ServerSpec) GroupNames() []string {
+	names := []string{}
+	for name, _ := range s.Groups {
+		names = append(names, name)
+	}
+	for _, node := range s.Nodes {
+		if node.Group != "" && InList(node.Group, names) == false {
+			names = append(names, node.Group)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Maps node names to groups. A group is either given explicit
+// node indexes (1-based) or a count of nodes, counted nodes are
+// spread round-robin across groups in order of node so that
+// services placed by InitNodeServices are spread across racks
+func (s *ServerSpec) InitNodeGroups() error {
+
+	s.NodeGroups = make(map[string]string)
+
+	// groups of explicit nodes are assigned first
+	for _, node := range s.Nodes {
+		if node.Group != "" {
+			s.NodeGroups[s.Names[node.Node-1]] = node.Group
+		}
+	}
+	if len(s.Groups) == 0 {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	groupNames := []string{}
+	for name, _ := range s.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	for _, group := range groupNames {
+		switch val := s.Groups[group].(type) {
+		case int:
+			counts[group] = val
+		case []interface{}:
+			for _, item := range val {
+				idx, err := strconv.Atoi(fmt.Sprintf("%v", item))
+				if err != nil {
+					return fmt.Errorf("servers %s: group %s has invalid node index %v", s.Name, group, item)
+				}
+				if err = s.assignNodeGroup(idx, group); err != nil {
+					return err
+				}
+			}
+		case string:
+			for _, item := range CommaStrToList(val) {
+				idx, err := strconv.Atoi(item)
+				if err != nil {
+					return fmt.Errorf("servers %s: group %s has invalid node index %q", s.Name, group, item)
+				}
+				if err = s.assignNodeGroup(idx, group); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("servers %s: group %s must be a node count or list of node indexes", s.Name, group)
+		}
+	}
+
+	// spread counted nodes across groups
+	i := 0
+	for _, name := range s.Names {
+		if _, ok := s.NodeGroups[name]; ok == true {
+			continue
+		}
+		assigned := false
+		for n := 0; n < len(groupNames) && assigned == false; n++ {
+			group := groupNames[(i+n)%len(groupNames)]
+			if counts[group] > 0 {
+				s.NodeGroups[name] = group
+				counts[group]--
+				i = i + n + 1
+				assigned = true
+			}
+		}
+	}
+
+	for _, group := range groupNames {
+		if counts[group] > 0 {
+			return fmt.Errorf("servers %s: not enough nodes for group %s", s.Name, group)
+		}
+	}
+	return nil
+}
+
+func (s *ServerSpec) assignNodeGroup(idx int, group string) error {
+	if idx < 1 || idx > len(s.Names) {
+		return fmt.Errorf("servers %s: group %s node index %d out of range", s.Name, group, idx)
+	}
+	name := s.Names[idx-1]
+	if other, ok := s.NodeGroups[name]; ok == true && other != group {
+		return fmt.Errorf("servers %s: node %d in both %s and %s", s.Name, idx, other, group)
+	}
+	s.NodeGroups[name] = group
+	return nil
+}
+
+// names of nodes assigned to group in order of spec
+func (s *ServerSpec) GroupNodeNames(group string) []string {
+	names := []string{}
+	for _, name := range s.Names {
+		if s.NodeGroups[name] == group {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// creates server groups of each cluster and moves
+// the orchestrator if assigned to other than default group,
+// remaining nodes are added into group by AddNodes
+func (s *Scope) CreateServerGroups() {
+
+	operation := func(name string, server *ServerSpec) {
+
+		if len(server.GroupNames()) == 0 {
+			return
+		}
+		orchestrator := server.Names[0]
+		ip := s.Provider.GetHostAddress(orchestrator)
+
+		for _, group := range server.GroupNames() {
+			if group == DEFAULT_SERVER_GROUP {
+				continue
+			}
+			command := []string{"group-manage", "-c", ip,
+				"-u", server.RestUsername, "-p", server.RestPassword,
+				"--create", "--group-name", group,
+			}
+			s.RunCliTask("group create "+group, command, orchestrator)
+		}
+
+		group := server.NodeGroups[orchestrator]
+		if group != "" && group != DEFAULT_SERVER_GROUP {
+			command := []string{"group-manage", "-c", ip,
+				"-u", server.RestUsername, "-p", server.RestPassword,
+				"--move-servers", ip + ":" + server.RestPort,
+				"--from-group", DEFAULT_SERVER_GROUP,
+				"--to-group", group,
+			}
+			s.RunCliTask("group move "+orchestrator+" to "+group, command, orchestrator)
+		}
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
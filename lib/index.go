@@ -0,0 +1,251 @@
+package sequoia
+
+/* Index.go
+ *
+ * Creates gsi indexes declared in scope spec
+ * and waits until they are online
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const INDEX_ONLINE_TIMEOUT = 10 * time.Minute
+
+// keyspace of index, ie.. `default`.`scope`.`coll`
+func (i *IndexSpec) Keyspace(bucketName string) string {
+	keyspace := fmt.Sprintf("`%s`", bucketName)
+	if i.Collection != "" {
+		keyspace = fmt.Sprintf("%s.`%s`.`%s`", keyspace, DefaultKeyspaceName(i.Scope), i.Collection)
+	}
+	return keyspace
+}
+
+// true when status is of the keyspace of index, servers
+// without collections report no scope or collection
+func (i *IndexSpec) InKeyspace(status IndexStatus, bucketName string) bool {
+	if status.Bucket != bucketName {
+		return false
+	}
+	return DefaultKeyspaceName(status.Scope) == DefaultKeyspaceName(i.Scope) &&
+		DefaultKeyspaceName(status.Collection) == DefaultKeyspaceName(i.Collection)
+}
+
+func DefaultKeyspaceName(name string) string {
+	if name == "" {
+		return "_default"
+	}
+	return name
+}
+
+// n1ql create statement, node addresses used for placement
+func (i *IndexSpec) CreateStatement(bucketName string, nodes []string) string {
+
+	var stmt string
+	if i.Primary == true {
+		stmt = fmt.Sprintf("CREATE PRIMARY INDEX `%s` ON %s", i.Name, i.Keyspace(bucketName))
+	} else {
+		stmt = fmt.Sprintf("CREATE INDEX `%s` ON %s(%s)", i.Name, i.Keyspace(bucketName), i.Fields)
+	}
+	if i.PartitionBy != "" {
+		stmt = fmt.Sprintf("%s PARTITION BY HASH(%s)", stmt, i.PartitionBy)
+	}
+	if i.Where != "" && i.Primary == false {
+		stmt = fmt.Sprintf("%s WHERE %s", stmt, i.Where)
+	}
+
+	with := make(map[string]interface{})
+	if i.Replicas > 0 {
+		with["num_replica"] = i.Replicas
+	}
+	if i.NumPartition > 0 {
+		with["num_partition"] = i.NumPartition
+	}
+	if len(nodes) > 0 {
+		with["nodes"] = nodes
+	}
+	if i.DeferBuild == true {
+		with["defer_build"] = true
+	}
+	if len(with) > 0 {
+		withJson, _ := json.Marshal(with)
+		stmt = fmt.Sprintf("%s WITH %s", stmt, withJson)
+	}
+	return stmt
+}
+
+// n1ql drop statement of index
+func (i *IndexSpec) DropStatement(bucketName string) string {
+	if i.Collection != "" {
+		return fmt.Sprintf("DROP INDEX `%s` ON %s", i.Name, i.Keyspace(bucketName))
+	}
+	return fmt.Sprintf("DROP INDEX %s.`%s`", i.Keyspace(bucketName), i.Name)
+}
+
+// true if any bucket of cluster declares indexes
+func (s *ServerSpec) HasIndexes() bool {
+	for _, bucket := range s.BucketSpecs {
+		if len(bucket.IndexSpecs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// runs n1ql statement through cbq against query node,
+// returns error of a non-zero exit which is also reported
+// as failed test point
+func (s *Scope) RunStatement(desc, stmt string, server *ServerSpec, queryNode string) error {
+	queryUrl := fmt.Sprintf("http://%s:%s",
+		s.Provider.GetHostAddress(queryNode), server.QueryPort)
+	command := []string{
+		"-e=" + queryUrl,
+		"-u=" + server.RestUsername,
+		"-p=" + server.RestPassword,
+		"-script=" + stmt,
+	}
+	task := ContainerTask{
+		Describe: desc,
+		Image:    "sequoiatools/cbq",
+		Command:  command,
+		Async:    false,
+	}
+	if s.Provider.GetType() == "docker" {
+		task.LinksTo = queryNode
+	}
+	_, echan := s.Cm.Run(&task)
+	if err := <-echan; err != nil {
+		ecolorsay(fmt.Sprintf("%s failed with exit status %s", desc, err))
+		return err
+	}
+	return nil
+}
+
+func (s *Scope) CreateIndexes() {
+
+	operation := func(name string, server *ServerSpec) {
+
+		if server.HasIndexes() == false {
+			return
+		}
+
+		queryNode, ok := s.ServiceNode(server, "n1ql")
+		if ok == false {
+			ecolorsay("cannot create indexes without query node in cluster " + server.Name)
+			return
+		}
+
+		// failed indexes are not waited on
+		failed := make(map[string]bool)
+		for _, bucket := range server.BucketSpecs {
+			for _, bucketName := range bucket.Names {
+				deferred := make(map[string][]IndexSpec)
+				for _, index := range bucket.IndexSpecs {
+					if index.Bucket != bucket.Name && index.Bucket != bucketName {
+						continue
+					}
+
+					// placement by node name
+					nodes := []string{}
+					for _, nodeName := range CommaStrToList(index.Nodes) {
+						if nodeName != "" {
+							ip := s.Provider.GetHostAddress(nodeName)
+							nodes = append(nodes, ip+":"+server.RestPort)
+						}
+					}
+
+					stmt := index.CreateStatement(bucketName, nodes)
+					err := s.RunStatement("index create "+index.Name, stmt, server, queryNode)
+					if err != nil {
+						failed[bucketName+"."+index.Name] = true
+						continue
+					}
+					if index.DeferBuild == true {
+						keyspace := index.Keyspace(bucketName)
+						deferred[keyspace] = append(deferred[keyspace], index)
+					}
+				}
+
+				// build deferred indexes of each keyspace together
+				for keyspace, indexes := range deferred {
+					names := []string{}
+					for _, index := range indexes {
+						names = append(names, "`"+index.Name+"`")
+					}
+					stmt := fmt.Sprintf("BUILD INDEX ON %s(%s)", keyspace, strings.Join(names, ","))
+					err := s.RunStatement("index build "+keyspace, stmt, server, queryNode)
+					if err != nil {
+						for _, index := range indexes {
+							failed[bucketName+"."+index.Name] = true
+						}
+					}
+				}
+			}
+		}
+
+		s.WaitForIndexes(server, failed)
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
+
+// blocks until declared indexes of cluster are ready,
+// except failed indexes keyed by bucket and index name
+func (s *Scope) WaitForIndexes(server *ServerSpec, failed map[string]bool) {
+
+	rest := s.Provider.GetRestUrl(server.Names[0])
+	start := time.Now()
+	for {
+		pending := []string{}
+		statuses, err := GetIndexStatus(rest, server.RestUsername, server.RestPassword)
+		for _, bucket := range server.BucketSpecs {
+			for _, bucketName := range bucket.Names {
+				for _, index := range bucket.IndexSpecs {
+					if index.Bucket != bucket.Name && index.Bucket != bucketName {
+						continue
+					}
+					if failed[bucketName+"."+index.Name] == true {
+						continue
+					}
+					if err != nil || IndexIsReady(statuses, bucketName, index) == false {
+						pending = append(pending, bucketName+"."+index.Name)
+					}
+				}
+			}
+		}
+
+		if len(pending) == 0 {
+			colorsay("indexes online")
+			return
+		}
+		if time.Since(start) > INDEX_ONLINE_TIMEOUT {
+			msg := UtilTaskMsg("[index]", fmt.Sprintf("timed out waiting for indexes of %s: %s",
+				server.Name, strings.Join(pending, ",")))
+			ecolorsay(msg)
+			s.Cm.TapHandle.Ok(false, msg)
+			return
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// true when index and all replicas are ready
+func IndexIsReady(statuses []IndexStatus, bucketName string, index IndexSpec) bool {
+	found := false
+	for _, status := range statuses {
+		if index.InKeyspace(status, bucketName) == false {
+			continue
+		}
+		if status.Index == index.Name || strings.HasPrefix(status.Index, index.Name+" (replica") {
+			found = true
+			if status.Status != "Ready" {
+				return false
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,376 @@
+package sequoia
+
+/* Override.go
+ *
+ * Overrides of scope spec fields and provider options
+ * given by -override and -override_file.
+ *
+ * An override is written as section:path=value where
+ * path is dot separated field names.  List entries are
+ * selected by name and map entries by key, ie..
+ *
+ *   servers:local.count=2
+ *   servers:local.services.index=2
+ *   buckets:default.replica=2
+ *   ddocs:scale.views=stats,padd
+ *   provider:build=5.0.0-3519
+ *
+ * The override file is a yaml patch with the same
+ * sections, ie..
+ *
+ *   buckets:
+ *     default:
+ *       replica: 2
+ */
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Override struct {
+	Section string
+	Path    []string
+	Value   string
+}
+
+func (o Override) String() string {
+	return fmt.Sprintf("%s:%s=%s", o.Section, strings.Join(o.Path, "."), o.Value)
+}
+
+// start of an override entry, a comma that is not
+// followed by one is part of the previous value
+var overrideEntryRe = regexp.MustCompile(`^\s*\w+:[^=]+=`)
+
+func ParseOverrides(overrides string) ([]Override, error) {
+	entries := []string{}
+	for _, part := range strings.Split(overrides, ",") {
+		if len(entries) > 0 && overrideEntryRe.MatchString(part) == false {
+			entries[len(entries)-1] += "," + part
+		} else {
+			entries = append(entries, part)
+		}
+	}
+
+	parsed := []Override{}
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		override, err := ParseOverride(entry)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, override)
+	}
+	return parsed, nil
+}
+
+func ParseOverride(entry string) (Override, error) {
+	var override Override
+
+	sep := strings.Index(entry, ":")
+	eq := strings.Index(entry, "=")
+	if sep < 1 || eq < sep+2 {
+		return override, fmt.Errorf("invalid override %q, expected section:path=value", entry)
+	}
+	override.Section = strings.TrimSpace(entry[:sep])
+	override.Path = strings.Split(strings.TrimSpace(entry[sep+1:eq]), ".")
+	override.Value = entry[eq+1:]
+	return override, nil
+}
+
+// overrides from yaml patch file
+func ReadOverrideFile(fileName string) ([]Override, error) {
+	source, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var patch map[string]interface{}
+	if err = yaml.Unmarshal(source, &patch); err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+
+	overrides := []Override{}
+	for _, section := range sortedKeys(patch) {
+		val := patch[section]
+		if _, ok := val.(map[interface{}]interface{}); ok == false {
+			return nil, fmt.Errorf("%s: section %s is not a map", fileName, section)
+		}
+		overrides = append(overrides, flattenOverride(section, []string{}, val)...)
+	}
+	return overrides, nil
+}
+
+// leaf values of patch as overrides, lists
+// are joined as comma separated value
+func flattenOverride(section string, path []string, val interface{}) []Override {
+	overrides := []Override{}
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		keyed := make(map[string]interface{})
+		for k, item := range v {
+			keyed[fmt.Sprintf("%v", k)] = item
+		}
+		for _, k := range sortedKeys(keyed) {
+			subpath := append(append([]string{}, path...), k)
+			overrides = append(overrides, flattenOverride(section, subpath, keyed[k])...)
+		}
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			items = append(items, fmt.Sprintf("%v", item))
+		}
+		overrides = append(overrides, Override{section, path, strings.Join(items, ",")})
+	case nil:
+		overrides = append(overrides, Override{section, path, ""})
+	default:
+		overrides = append(overrides, Override{section, path, fmt.Sprintf("%v", v)})
+	}
+	return overrides
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for k, _ := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// overrides from file followed by
+// those of override flag
+func OverridesFromFlags(flags TestFlags) []Override {
+	overrides := []Override{}
+	if flags.OverrideFile != nil && *flags.OverrideFile != "" {
+		fileOverrides, err := ReadOverrideFile(*flags.OverrideFile)
+		logerr(err)
+		overrides = append(overrides, fileOverrides...)
+	}
+	if flags.Override != nil {
+		flagOverrides, err := ParseOverrides(*flags.Override)
+		logerr(err)
+		overrides = append(overrides, flagOverrides...)
+	}
+	return overrides
+}
+
+func ProviderOverrides(overrides []Override) []Override {
+	providerOverrides := []Override{}
+	for _, override := range overrides {
+		if override.Section == "provider" {
+			providerOverrides = append(providerOverrides, override)
+		}
+	}
+	return providerOverrides
+}
+
+// applies spec overrides, provider overrides
+// are only checked against provider options
+func ApplyOverrides(overrides []Override, spec *ScopeSpec) error {
+	for _, override := range overrides {
+		var err error
+		if override.Section == "provider" {
+			var opts DockerProviderOpts
+			err = SetFieldPath(reflect.ValueOf(&opts), override.Path, override.Value)
+		} else {
+			path := append([]string{override.Section}, override.Path...)
+			err = SetFieldPath(reflect.ValueOf(spec), path, override.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("override %s: %s", override, err)
+		}
+	}
+	return nil
+}
+
+func ApplyProviderOverrides(overrides []Override, opts *DockerProviderOpts) error {
+	for _, override := range ProviderOverrides(overrides) {
+		err := SetFieldPath(reflect.ValueOf(opts), override.Path, override.Value)
+		if err != nil {
+			return fmt.Errorf("override %s: %s", override, err)
+		}
+	}
+	return nil
+}
+
+// sets value at path of struct fields, named list
+// entries and map keys below v
+func SetFieldPath(v reflect.Value, path []string, value string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if len(path) == 0 && value == "" {
+			// unset pointer field to use default
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return SetFieldPath(v.Elem(), path, value)
+	case reflect.Struct:
+		if len(path) == 0 {
+			return fmt.Errorf("missing field of %s", v.Type().Name())
+		}
+		field, ok := fieldByYamlName(v, path[0])
+		if ok == false {
+			return fmt.Errorf("unknown field %s", path[0])
+		}
+		return SetFieldPath(field, path[1:], value)
+	case reflect.Slice:
+		if len(path) == 0 {
+			return setLeafValue(v, value)
+		}
+		i, n, ok := sliceEntry(v, path)
+		if ok == false {
+			return fmt.Errorf("unknown entry %s", strings.Join(path, "."))
+		}
+		return SetFieldPath(v.Index(i), path[n:], value)
+	case reflect.Map:
+		if len(path) == 0 {
+			return fmt.Errorf("missing key of map")
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("map of %s is not keyed by name", path[0])
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key, rest := path[0], path[1:]
+		if isLeafKind(v.Type().Elem().Kind()) {
+			// keys such as node names may contain dots
+			key, rest = strings.Join(path, "."), []string{}
+		}
+		mapKey := reflect.ValueOf(key).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(mapKey); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := SetFieldPath(elem, rest, value); err != nil {
+			return err
+		}
+		v.SetMapIndex(mapKey, elem)
+		return nil
+	}
+
+	if len(path) > 0 {
+		return fmt.Errorf("unknown field %s", path[0])
+	}
+	return setLeafValue(v, value)
+}
+
+func isLeafKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Struct, reflect.Ptr, reflect.Map:
+		return false
+	}
+	return true
+}
+
+// struct field by yaml key or camelcased name
+func fieldByYamlName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == name || field.Name == ToCamelCase(name) ||
+			strings.EqualFold(field.Name, strings.Replace(name, "_", "", -1)) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// index of list entry named by leading parts of path
+// and number of parts used, names may contain dots.
+// entries without name are selected by 1-based index
+func sliceEntry(v reflect.Value, path []string) (int, int, bool) {
+	for n := len(path); n > 0; n-- {
+		name := strings.Join(path[:n], ".")
+		for i := 0; i < v.Len(); i++ {
+			if entryName(v.Index(i)) == name {
+				return i, n, true
+			}
+		}
+	}
+	if i, err := strconv.Atoi(path[0]); err == nil && i > 0 && i <= v.Len() {
+		return i - 1, 1, true
+	}
+	return 0, 0, false
+}
+
+func entryName(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	for _, name := range []string{"Name", "Node"} {
+		field := v.FieldByName(name)
+		if field.IsValid() {
+			return fmt.Sprintf("%v", field.Interface())
+		}
+	}
+	return ""
+}
+
+func setLeafValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Interface:
+		// parsed as yaml, ie.. counts remain ints
+		var parsed interface{}
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			return err
+		}
+		if parsed == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(parsed))
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("cannot set list of %s", v.Type().Elem())
+		}
+		v.Set(reflect.ValueOf(CommaStrToList(value)).Convert(v.Type()))
+	default:
+		return fmt.Errorf("cannot set field of kind %s", v.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package sequoia
+
+/* Params.go
+ *
+ * Binds values of calling action to the params declared
+ * by a template.  Params are referenced as template
+ * variables, ie.. {{$bucket}}, and are declared in each
+ * field that uses them rather than replaced in yaml.
+ * Templates without params bind positional args to
+ * implicit params $0, $1 .. $n in the same way.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type ParamValues struct {
+	Names    []string
+	Literals map[string]string
+}
+
+// resolve template with declared params
+func (t *Test) ResolveTemplateParams(scope Scope, action ActionSpec, template TemplateSpec) []ActionSpec {
+
+	var resolvedActions = []ActionSpec{}
+	values, err := BindTemplateParams(&scope, template.Params, action)
+	if err != nil {
+		t.ReportFailure(&scope, "[spec error]",
+			fmt.Sprintf("template %s: %s", template.Name, err))
+		return resolvedActions
+	}
+
+	actions := template.Actions
+	if template.ForEach != "" {
+		// params are visible within range
+		rangeStr := values.DeclareAll() + template.ForEach
+		actions = t.ResolveTemplateRangeActions(scope, actions, rangeStr)
+	}
+
+	for _, subAction := range actions {
+		values.DeclareAction(&subAction)
+		t.InheritActionValues(action, &subAction)
+		resolvedActions = append(resolvedActions, subAction)
+	}
+	return resolvedActions
+}
+
+// bind values from 'with' map, positional 'args' or defaults
+func BindTemplateParams(scope *Scope, params []ParamSpec, action ActionSpec) (ParamValues, error) {
+
+	values := ParamValues{
+		Names:    []string{},
+		Literals: make(map[string]string),
+	}
+
+	positional := []string{}
+	if action.Args != "" {
+		positional = SplitTemplateArgs(ParseTemplate(scope, action.Args))
+	}
+
+	declared := make(map[string]bool)
+	for i, param := range params {
+		declared[param.Name] = true
+
+		var raw string
+		if val, ok := action.With[param.Name]; ok {
+			raw = WithValueString(scope, val)
+		} else if i < len(positional) {
+			raw = positional[i]
+		} else if param.Default != "" {
+			raw = ParseTemplate(scope, param.Default)
+		} else if param.Required == true {
+			return values, fmt.Errorf("missing required param %q", param.Name)
+		}
+
+		literal, err := param.Literal(raw)
+		if err != nil {
+			return values, err
+		}
+		values.Names = append(values.Names, param.Name)
+		values.Literals[param.Name] = literal
+	}
+
+	for key, _ := range action.With {
+		if declared[key] == false {
+			return values, fmt.Errorf("unknown param %q", key)
+		}
+	}
+	return values, nil
+}
+
+// render string values in scope of calling action
+func WithValueString(scope *Scope, val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return ParseTemplate(scope, v)
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			items = append(items, fmt.Sprintf("%v", item))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// converts raw value to a template literal of param type
+func (p *ParamSpec) Literal(raw string) (string, error) {
+
+	raw = strings.TrimSpace(raw)
+	switch p.Type {
+	case "", "string":
+		return QuoteLiteral(raw), nil
+	case "int":
+		if raw == "" {
+			return "0", nil
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return "", fmt.Errorf("param %q expects int: %q", p.Name, raw)
+		}
+		return strconv.Itoa(v), nil
+	case "float":
+		if raw == "" {
+			return "0.0", nil
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return "", fmt.Errorf("param %q expects float: %q", p.Name, raw)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case "bool":
+		if raw == "" {
+			return "false", nil
+		}
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", fmt.Errorf("param %q expects bool: %q", p.Name, raw)
+		}
+		return strconv.FormatBool(v), nil
+	case "list":
+		items := []string{}
+		if raw != "" {
+			for _, item := range CommaStrToList(raw) {
+				items = append(items, QuoteLiteral(item))
+			}
+		}
+		return fmt.Sprintf("(strlist %s)", strings.Join(items, " ")), nil
+	}
+	return "", fmt.Errorf("param %q has unknown type %q", p.Name, p.Type)
+}
+
+// raw string literal unless value contains backquote
+func QuoteLiteral(val string) string {
+	if strings.Index(val, "`") == -1 {
+		return "`" + val + "`"
+	}
+	return strconv.Quote(val)
+}
+
+// declaration of all params
+func (v *ParamValues) DeclareAll() string {
+	decls := ""
+	for _, name := range v.Names {
+		decls = decls + fmt.Sprintf("{{$%s := %s}}", name, v.Literals[name])
+	}
+	return decls
+}
+
+// prefix text with declarations of params it references
+func (v *ParamValues) Declare(text string) string {
+	decls := ""
+	for _, name := range v.Names {
+		ref := regexp.MustCompile(`\$` + regexp.QuoteMeta(name) + `\b`)
+		if ref.MatchString(text) {
+			decls = decls + fmt.Sprintf("{{$%s := %s}}", name, v.Literals[name])
+		}
+	}
+	return decls + text
+}
+
+// declare params in all action fields that are rendered
+func (v *ParamValues) DeclareAction(a *ActionSpec) {
+	MapRenderedFields(a, v.Declare)
+}
+
+// applies fn to each action field that is rendered, lists
+// and maps are copied as they are shared with the template
+func MapRenderedFields(a *ActionSpec, fn func(string) string) {
+	a.Command = fn(a.Command)
+	a.Alias = fn(a.Alias)
+	a.Requires = fn(a.Requires)
+	a.Until = fn(a.Until)
+	a.Before = fn(a.Before)
+	a.CondWait = fn(a.CondWait)
+	a.Concurrency = fn(a.Concurrency)
+	a.Duration = fn(a.Duration)
+	a.Args = fn(a.Args)
+	a.UntilTimeout = fn(a.UntilTimeout)
+	a.BeforeTimeout = fn(a.BeforeTimeout)
+	a.PollInterval = fn(a.PollInterval)
+	a.ForEach = fn(a.ForEach)
+	a.Client.ToPath = fn(a.Client.ToPath)
+
+	asserts := []string{}
+	for _, assert := range a.Assert {
+		asserts = append(asserts, fn(assert))
+	}
+	a.Assert = asserts
+
+	if len(a.With) > 0 {
+		with := make(map[string]interface{})
+		for key, val := range a.With {
+			if str, ok := val.(string); ok {
+				val = fn(str)
+			}
+			with[key] = val
+		}
+		a.With = with
+	}
+}
+
+// positional args of templates without declared params
+// are bound to implicit params $0, $1 .. $n
+func PositionalParamValues(args []string) ParamValues {
+	values := ParamValues{
+		Names:    []string{},
+		Literals: make(map[string]string),
+	}
+	for i, arg := range args {
+		name := strconv.Itoa(i)
+		values.Names = append(values.Names, name)
+		values.Literals[name] = PositionalLiteral(arg)
+	}
+	return values
+}
+
+// ints remain ints so that they can be compared
+// within template actions, ie.. {{gt .Loop $1}}
+func PositionalLiteral(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if v, err := strconv.Atoi(arg); err == nil && strconv.Itoa(v) == arg {
+		return arg
+	}
+	return QuoteLiteral(arg)
+}
+
+var positionalRefRe = regexp.MustCompile(`\$(\d+)\b`)
+var templateActionRe = regexp.MustCompile(`(?s){{.*?}}`)
+
+// references to positional params outside of template
+// actions become actions, ie.. -n $0 is -n {{$0}}.
+// references beyond the given args are left as is
+func (v *ParamValues) ActionRefs(text string) string {
+	rewrite := func(part string) string {
+		return positionalRefRe.ReplaceAllStringFunc(part, func(ref string) string {
+			if _, ok := v.Literals[ref[1:]]; ok == true {
+				return "{{" + ref + "}}"
+			}
+			return ref
+		})
+	}
+
+	rewritten := ""
+	last := 0
+	for _, loc := range templateActionRe.FindAllStringIndex(text, -1) {
+		rewritten += rewrite(text[last:loc[0]]) + text[loc[0]:loc[1]]
+		last = loc[1]
+	}
+	return rewritten + rewrite(text[last:])
+}
+
+// positional params are substituted in fields that
+// are not rendered, ie.. image and client container
+func SubstitutePositional(text string, args []string) string {
+	return positionalRefRe.ReplaceAllStringFunc(text, func(ref string) string {
+		i, _ := strconv.Atoi(ref[1:])
+		if i < len(args) {
+			return strings.TrimSpace(args[i])
+		}
+		return ref
+	})
+}
+
+// binds positional args in all fields of action
+func (v *ParamValues) BindPositional(a *ActionSpec, args []string) {
+	a.Image = SubstitutePositional(a.Image, args)
+	a.Entrypoint = SubstitutePositional(a.Entrypoint, args)
+	a.Template = SubstitutePositional(a.Template, args)
+	a.Client.Container = SubstitutePositional(a.Client.Container, args)
+	a.Client.FromPath = SubstitutePositional(a.Client.FromPath, args)
+
+	MapRenderedFields(a, v.ActionRefs)
+	v.DeclareAction(a)
+}
@@ -0,0 +1,35 @@
+package sequoia
+
+/* Placement.go
+ *
+ * Prints the node to service placement of a scope
+ * spec without provisioning any resources
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+func PrintPlacement(spec ScopeSpec) {
+	for _, server := range spec.Servers {
+		fmt.Printf("cluster %s (%d nodes, %d init)\n", server.Name, server.Count, server.InitNodes)
+		fmt.Printf("  %-30s %-35s %-12s %s\n", "NODE", "SERVICES", "GROUP", "INIT")
+		for i, name := range server.Names {
+			services := strings.Join(server.NodeServices[name], ",")
+			group := server.NodeGroups[name]
+			if group == "" {
+				group = DEFAULT_SERVER_GROUP
+			}
+			init := "no"
+			if i < int(server.InitNodes) {
+				init = "yes"
+			}
+			if i == 0 {
+				init = "orchestrator"
+			}
+			fmt.Printf("  %-30s %-35s %-12s %s\n", name, services, group, init)
+		}
+		fmt.Println()
+	}
+}
@@ -51,6 +51,7 @@ type DockerProvider struct {
 	ActiveContainers map[string]string
 	StartPort        int
 	Opts             *DockerProviderOpts
+	Overrides        []Override
 }
 
 type SwarmProvider struct {
@@ -75,6 +76,7 @@ func (opts *DockerProviderOpts) MemoryMB() int {
 func NewProvider(flags TestFlags, servers []ServerSpec) Provider {
 	var provider Provider
 	providerArgs := strings.Split(*flags.Provider, ":")
+	overrides := ProviderOverrides(OverridesFromFlags(flags))
 
 	switch providerArgs[0] {
 	case "docker":
@@ -85,6 +87,7 @@ func NewProvider(flags TestFlags, servers []ServerSpec) Provider {
 			make(map[string]string),
 			8091,
 			nil,
+			overrides,
 		}
 	case "swarm":
 		cm := NewContainerManager(*flags.Client, "swarm")
@@ -94,7 +97,8 @@ func NewProvider(flags TestFlags, servers []ServerSpec) Provider {
 				servers,
 				make(map[string]string),
 				8091,
-				nil},
+				nil,
+				overrides},
 		}
 	case "file":
 		hostFile := "default.yml"
@@ -118,6 +122,9 @@ func NewProvider(flags TestFlags, servers []ServerSpec) Provider {
 		}
 	}
 
+	if len(overrides) > 0 && provider.GetType() != "docker" && provider.GetType() != "swarm" {
+		logerrstr("provider overrides only apply to docker and swarm providers")
+	}
 	return provider
 }
 
@@ -247,6 +254,7 @@ func (p *DockerProvider) ProvideCouchbaseServers(servers []ServerSpec) {
 
 	var providerOpts DockerProviderOpts
 	ReadYamlFile("providers/docker/options.yml", &providerOpts)
+	logerr(ApplyProviderOverrides(p.Overrides, &providerOpts))
 	p.Opts = &providerOpts
 	var build = p.Opts.Build
 
@@ -362,6 +370,7 @@ func (p *DockerProvider) ProvideCouchbaseServers(servers []ServerSpec) {
 }
 
 func (p *SwarmProvider) ProvideCouchbaseServer(serverName string, portOffset int, zone string) {
+	defer RecoverFatal()
 
 	var build = p.Opts.Build
 
@@ -452,6 +461,7 @@ func (p *SwarmProvider) ProvideCouchbaseServers(servers []ServerSpec) {
 	// read provider options
 	var providerOpts DockerProviderOpts
 	ReadYamlFile("providers/docker/options.yml", &providerOpts)
+	logerr(ApplyProviderOverrides(p.Overrides, &providerOpts))
 	p.Opts = &providerOpts
 
 	// start based on number of containers
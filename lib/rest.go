@@ -1,10 +1,12 @@
 package sequoia
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -19,6 +21,32 @@ type NodeSelf struct {
 	Version           string
 }
 
+type PoolsDefault struct {
+	Nodes []PoolNode
+}
+
+type PoolNode struct {
+	Hostname          string
+	Services          []string
+	ClusterMembership string
+	Status            string
+	ThisNode          bool
+}
+
+type IndexStatus struct {
+	Index      string
+	Bucket     string
+	Scope      string
+	Collection string
+	Status     string
+}
+
+type RemoteCluster struct {
+	Name    string
+	Uuid    string
+	Deleted bool
+}
+
 type NodeStatuses struct {
 	Statuses map[string]NodeStatus
 }
@@ -115,14 +143,160 @@ func NodeIsSingle(host, user, password string) bool {
 	return single
 }
 
+// remote cluster references of cluster
+func GetRemoteClusters(host, user, password string) ([]RemoteCluster, error) {
+	var remotes []RemoteCluster
+	err := _jsonRequest("http://%s/pools/default/remoteClusters", host, user, password, &remotes)
+	return remotes, err
+}
+
+// uuid of remote cluster reference used in replication id
+func GetRemoteClusterUuid(host, user, password, name string) (string, error) {
+	remotes, err := GetRemoteClusters(host, user, password)
+	if err != nil {
+		return "", err
+	}
+	for _, remote := range remotes {
+		if remote.Name == name && remote.Deleted == false {
+			return remote.Uuid, nil
+		}
+	}
+	return "", fmt.Errorf("no remote cluster named %q", name)
+}
+
+// status of all gsi indexes in cluster
+func GetIndexStatus(host, user, password string) ([]IndexStatus, error) {
+	var res struct {
+		Indexes []IndexStatus
+	}
+	err := _jsonRequest("http://%s/indexStatus", host, user, password, &res)
+	return res.Indexes, err
+}
+
+// names of all buckets in cluster
+func GetBucketNames(host, user, password string) ([]string, error) {
+	var buckets []struct {
+		Name string
+	}
+	err := _jsonRequest("http://%s/pools/default/buckets", host, user, password, &buckets)
+	names := []string{}
+	for _, bucket := range buckets {
+		names = append(names, bucket.Name)
+	}
+	return names, err
+}
+
+// names of design docs in bucket without _design/ prefix
+func GetDDocNames(host, user, password, bucket string) ([]string, error) {
+	var res struct {
+		Rows []struct {
+			Doc struct {
+				Meta struct {
+					Id string
+				}
+			}
+		}
+	}
+	err := _jsonRequest("http://%s/pools/default/buckets/"+bucket+"/ddocs", host, user, password, &res)
+	names := []string{}
+	for _, row := range res.Rows {
+		names = append(names, strings.TrimPrefix(row.Doc.Meta.Id, "_design/"))
+	}
+	return names, err
+}
+
+// rebalance status of cluster, ie.. none or running
+func GetRebalanceStatus(host, user, password string) (string, error) {
+	var res struct {
+		Status string
+	}
+	err := _jsonRequest("http://%s/pools/default/rebalanceProgress", host, user, password, &res)
+	return res.Status, err
+}
+
 func getNodeStatus(host, user, password string, v interface{}) error {
 	return _jsonRequest("http://%s/nodeStatuses", host, user, password, v)
 }
 
+func getPoolsDefault(host, user, password string, v interface{}) error {
+	return _jsonRequest("http://%s/pools/default", host, user, password, v)
+}
+
 func getNodeSelf(host, user, password string, v interface{}) error {
 	return _jsonRequest("http://%s/nodes/self", host, user, password, v)
 }
 
+// fts rest api is served on port 8094 of fts nodes
+func FtsHost(address string) string {
+	return strings.Split(address, ":")[0] + ":8094"
+}
+
+func PutFtsIndex(host, user, password, name string, def []byte) error {
+	return _restRequest("PUT", "http://%s/api/index/"+name, host, user, password, def)
+}
+
+func DeleteFtsIndex(host, user, password, name string) error {
+	return _restRequest("DELETE", "http://%s/api/index/"+name, host, user, password, nil)
+}
+
+type FtsIndexDefs struct {
+	IndexDefs struct {
+		IndexDefs map[string]interface{}
+	}
+}
+
+type RbacUser struct {
+	Id     string
+	Domain string
+}
+
+type ServerGroups struct {
+	Groups []struct {
+		Name string
+	}
+}
+
+// names of fts indexes defined on cluster
+func GetFtsIndexNames(host, user, password string) ([]string, error) {
+	var defs FtsIndexDefs
+	names := []string{}
+	err := _jsonRequest("http://%s/api/index", host, user, password, &defs)
+	for name, _ := range defs.IndexDefs.IndexDefs {
+		names = append(names, name)
+	}
+	return names, err
+}
+
+// names of local rbac users
+func GetRbacUserNames(host, user, password string) ([]string, error) {
+	var users []RbacUser
+	names := []string{}
+	err := _jsonRequest("http://%s/settings/rbac/users", host, user, password, &users)
+	for _, u := range users {
+		if u.Domain == "" || u.Domain == "local" {
+			names = append(names, u.Id)
+		}
+	}
+	return names, err
+}
+
+func GetServerGroupNames(host, user, password string) ([]string, error) {
+	var groups ServerGroups
+	names := []string{}
+	err := _jsonRequest("http://%s/pools/default/serverGroups", host, user, password, &groups)
+	for _, g := range groups.Groups {
+		names = append(names, g.Name)
+	}
+	return names, err
+}
+
+// partition stats of fts index
+func GetFtsIndexStats(host, user, password, name string) (map[string]interface{}, error) {
+	var stats map[string]interface{}
+	err := _jsonRequest("http://%s/api/nsstats/index/"+name, host, user, password, &stats)
+	return stats, err
+}
+
 func _jsonRequest(url, host, user, password string, v interface{}) error {
 
 	// setup request url
@@ -141,7 +315,33 @@ func _jsonRequest(url, host, user, password string, v interface{}) error {
 	// unmarshal data to provided interface
 	body, err := ioutil.ReadAll(res.Body)
 	chkerr(err)
-	err = json.Unmarshal(body, v)
+	return json.Unmarshal(body, v)
+}
+
+// request with json body, response other than 2xx is an error
+func _restRequest(method, url, host, user, password string, data []byte) error {
+
+	urlStr := fmt.Sprintf(url, host)
+	req, err := http.NewRequest(method, urlStr, bytes.NewReader(data))
 	chkerr(err)
+	req.SetBasicAuth(user, password)
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s %s", method, urlStr, res.Status, strings.TrimSpace(string(body)))
+	}
 	return nil
 }
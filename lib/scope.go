@@ -15,7 +15,6 @@ package sequoia
 import (
 	"fmt"
 	"github.com/streamrail/concurrent-map"
-	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -28,68 +27,56 @@ type Scope struct {
 	Flags    TestFlags
 	Version  string
 	Vars     cmap.ConcurrentMap
+	Captured cmap.ConcurrentMap
 	Loops    int
+	Topology *TopologyCache
+	Settings map[string]SettingsSpec
 }
 
-func ApplyOverrides(overrides string, spec *ScopeSpec) {
-	parts := strings.Split(overrides, ",")
-	for _, component := range parts {
-		subparts := strings.Split(component, ":")
-		if len(subparts) > 2 {
-			// rejoin if already had colon
-			subparts = []string{subparts[0],
-				strings.Join(subparts[1:], ":"),
-			}
-		}
-		if len(subparts) == 2 {
-			key := subparts[0]
-			vals := strings.Split(subparts[1], ".")
-
-			switch key {
-			case "servers": // server override
-				for i, server := range spec.Servers {
-					if server.Name == vals[0] {
-						attrs := strings.Split(vals[1], "=")
-						_k := ToCamelCase(attrs[0])
-						_v := attrs[1]
-
-						// reflect to spec field
-						rspec := reflect.ValueOf(&server)
-						el := rspec.Elem()
-						val := el.FieldByName(_k)
-						switch val.Kind() {
-						case reflect.Uint8:
-							// update fuild as uint
-							u, _ := strconv.ParseUint(_v, 10, 8)
-							val.SetUint(u)
-						case reflect.String:
-							// update fuild as string
-							val.SetString(_v)
-						}
-						spec.Servers[i] = server
-					}
-				}
-			} // TODO: add in bucket, ddoc overrides
-		}
-	}
-
-}
-
-func NewScope(flags TestFlags, cm *ContainerManager) Scope {
+// scope spec from file with overrides applied
+func ScopeSpecFromFlags(flags TestFlags) ScopeSpec {
 
 	// init from yaml or ini
-	spec := NewScopeSpec(*flags.ScopeFile)
+	spec := NewScopeSpecWithVars(*flags.ScopeFile, flags.Vars, *flags.Scale)
 
 	// apply overrides
-	if params := flags.Override; params != nil {
-		ApplyOverrides(*params, &spec)
+	if overrides := OverridesFromFlags(flags); len(overrides) > 0 {
+		logerr(ApplyOverrides(overrides, &spec))
 		ConfigureSpec(&spec)
 	}
+	return spec
+}
+
+func NewScope(flags TestFlags, cm *ContainerManager) Scope {
+
+	spec := ScopeSpecFromFlags(flags)
 
 	// create provider of resources for scope
 	provider := NewProvider(flags, spec.Servers)
 
-	// update defaults from spec based on provider
+	SetProviderDefaults(&spec, provider)
+
+	var loops = 0
+	if *flags.Continue == true {
+		loops++ // we've already done first pass
+	}
+
+	return Scope{
+		spec,
+		cm,
+		provider,
+		flags,
+		"",
+		cmap.New(),
+		cmap.New(),
+		loops,
+		NewTopologyCache(),
+		make(map[string]SettingsSpec),
+	}
+}
+
+// update defaults from spec based on provider
+func SetProviderDefaults(spec *ScopeSpec, provider Provider) {
 	for i, _ := range spec.Servers {
 		// set default port services
 		if spec.Servers[i].RestPort == "" {
@@ -119,40 +106,29 @@ func NewScope(flags TestFlags, cm *ContainerManager) Scope {
 			}
 		}
 	}
-	var loops = 0
-	if *flags.Continue == true {
-		loops++ // we've already done first pass
-	}
-
-	return Scope{
-		spec,
-		cm,
-		provider,
-		flags,
-		"",
-		cmap.New(),
-		loops,
-	}
 }
 
 func (s *Scope) Setup() {
 
 	s.WaitForNodes()
 	s.InitCli()
+	s.ValidateBuckets(s.Spec)
+	s.ValidateServices(s.Spec)
 	s.InitNodes()
 	s.InitCluster()
+	s.ApplySettings()
+	s.CreateServerGroups()
 	s.AddNodes()
 	s.RebalanceClusters()
 	s.CreateBuckets()
+	s.CreateCollections()
+	s.CreateUsers()
+	s.CreateIndexes()
+	s.CreateFtsIndexes()
+	s.CreateXdcr()
 	s.CreateViews()
 }
 
-func (s *Scope) Teardown() {
-	// descope
-	s.DeleteBuckets()
-	s.RemoveNodes()
-}
-
 func (s *Scope) InitCli() {
 
 	// make sure proper couchbase-cli is used for node init
@@ -168,6 +144,20 @@ func (s *Scope) InitCli() {
 
 }
 
+// bucket settings must be supported by cluster version
+func (s *Scope) ValidateBuckets(spec ScopeSpec) {
+	if err := ValidateBucketSpecs(spec, s.Version); err != nil {
+		logerrstr(fmt.Sprintf("invalid bucket settings for version %s: %s", s.Version, err))
+	}
+}
+
+// services of nodes must be supported by cluster version
+func (s *Scope) ValidateServices(spec ScopeSpec) {
+	if err := ValidateServiceSpecs(spec, s.Version); err != nil {
+		logerrstr(fmt.Sprintf("invalid services for version %s: %s", s.Version, err))
+	}
+}
+
 func (s *Scope) WaitForNodes() {
 
 	var image = "martin/wait"
@@ -215,10 +205,10 @@ func (s *Scope) InitNodes() {
 		}
 
 		if s.Provider.GetType() == "file" {
-			command = append(command, "--node-init-data-path", server.DataPath)
+			command = append(command, "--node-init-data-path", server.NodeDataPath(name))
 		}
 		if s.Provider.GetType() == "file" {
-			command = append(command, "--node-init-index-path", server.IndexPath)
+			command = append(command, "--node-init-index-path", server.NodeIndexPath(name))
 		}
 		desc := "init node " + ip
 		task := ContainerTask{
@@ -298,6 +288,32 @@ func (s *Scope) InitCluster() {
 			}
 			command = append(command, "--cluster-fts-ramsize", server.FtsRam)
 		}
+		// make sure if eventing services is specified that eventing ram is set
+		if strings.Index(services, "eventing") > -1 && server.EventingRam == "" {
+			server.EventingRam = "256"
+		}
+		if server.EventingRam != "" {
+			eventingQuota := server.EventingRam
+			if strings.Index(eventingQuota, "%") > -1 {
+				// use percentage of memtotal
+				eventingQuota := s.GetPercOfMemTotal(name, server, eventingQuota)
+				server.EventingRam = eventingQuota
+			}
+			command = append(command, "--cluster-eventing-ramsize", server.EventingRam)
+		}
+		// make sure if analytics services is specified that analytics ram is set
+		if strings.Index(services, "analytics") > -1 && server.AnalyticsRam == "" {
+			server.AnalyticsRam = "1024"
+		}
+		if server.AnalyticsRam != "" {
+			analyticsQuota := server.AnalyticsRam
+			if strings.Index(analyticsQuota, "%") > -1 {
+				// use percentage of memtotal
+				analyticsQuota := s.GetPercOfMemTotal(name, server, analyticsQuota)
+				server.AnalyticsRam = analyticsQuota
+			}
+			command = append(command, "--cluster-analytics-ramsize", server.AnalyticsRam)
+		}
 
 		if server.IndexStorage != "" {
 			command = append(command, "--index-storage-setting", server.IndexStorage)
@@ -327,53 +343,64 @@ func (s *Scope) InitCluster() {
 
 func (s *Scope) AddNodes() {
 
-	var image = "sequoiatools/couchbase-cli"
-
 	addNodesOp := func(name string, server *ServerSpec) {
 
 		if server.InitNodes <= server.NodesActive {
 			return
 		}
 		orchestrator := server.Names[0]
-		orchestratorIp := s.Provider.GetHostAddress(orchestrator)
-		ip := s.Provider.GetHostAddress(name)
-
 		if name == orchestrator {
 			return // not adding self
 		}
 
-		servicesList := server.NodeServices[name]
-		services := strings.Join(servicesList, ",")
-		command := []string{"server-add",
-			"-c", orchestratorIp,
-			"-u", server.RestUsername,
-			"-p", server.RestPassword,
-			"--server-add", ip,
-			"--server-add-username", server.RestUsername,
-			"--server-add-password", server.RestPassword,
-			"--services", services,
-		}
-
-		desc := "add node " + ip
-		command = cliCommandValidator(s.Version, command)
-
-		task := ContainerTask{
-			Describe: desc,
-			Image:    image,
-			Command:  command,
-			Async:    false,
-		}
-		if s.Provider.GetType() == "docker" {
-			task.LinksTo = orchestrator
-		}
-
-		s.Cm.Run(&task)
+		s.AddNode(name, server)
 		server.NodesActive++
 
 	}
 
 	// add nodes
 	s.Spec.ApplyToAllServers(addNodesOp)
+	s.Topology.Invalidate()
+}
+
+// adds node to cluster with its services and group
+func (s *Scope) AddNode(name string, server *ServerSpec) {
+
+	var image = "sequoiatools/couchbase-cli"
+
+	orchestrator := server.Names[0]
+	orchestratorIp := s.Provider.GetHostAddress(orchestrator)
+	ip := s.Provider.GetHostAddress(name)
+
+	servicesList := server.NodeServices[name]
+	services := strings.Join(servicesList, ",")
+	command := []string{"server-add",
+		"-c", orchestratorIp,
+		"-u", server.RestUsername,
+		"-p", server.RestPassword,
+		"--server-add", ip,
+		"--server-add-username", server.RestUsername,
+		"--server-add-password", server.RestPassword,
+		"--services", services,
+	}
+	if group := server.NodeGroups[name]; group != "" {
+		command = append(command, "--group-name", group)
+	}
+
+	desc := "add node " + ip
+	command = cliCommandValidator(s.Version, command)
+
+	task := ContainerTask{
+		Describe: desc,
+		Image:    image,
+		Command:  command,
+		Async:    false,
+	}
+	if s.Provider.GetType() == "docker" {
+		task.LinksTo = orchestrator
+	}
+
+	s.Cm.Run(&task)
 }
 
 func (s *Scope) RebalanceClusters() {
@@ -406,60 +433,17 @@ func (s *Scope) RebalanceClusters() {
 
 	// apply only to orchestrator
 	s.Spec.ApplyToServers(operation, 0, 1)
+	s.Topology.Invalidate()
 
 }
 
 func (s *Scope) CreateBuckets() {
 
-	var image = "sequoiatools/couchbase-cli"
-
 	// configure rebalance operation
 	operation := func(name string, server *ServerSpec) {
-
-		orchestrator := server.Names[0]
-		ip := s.Provider.GetHostAddress(orchestrator)
-
 		for _, bucket := range server.BucketSpecs {
 			for _, bucketName := range bucket.Names {
-				ramQuota := bucket.Ram
-				if strings.Index(ramQuota, "%") > -1 {
-					// convert bucket ram to value within context of server ram
-					ramQuota = strings.Replace(ramQuota, "%", "", 1)
-					ramVal, _ := strconv.Atoi(ramQuota)
-					nodeRam, _ := strconv.Atoi(server.Ram)
-					ramQuota = strconv.Itoa((nodeRam * ramVal) / 100)
-				}
-				var replica uint8 = 1
-				if bucket.Replica != nil {
-					replica = *bucket.Replica
-				}
-				command := []string{"bucket-create", "-c", ip,
-					"-u", server.RestUsername, "-p", server.RestPassword,
-					"--bucket", bucketName,
-					"--bucket-ramsize", ramQuota,
-					"--bucket-type", bucket.Type,
-					"--bucket-replica", strconv.Itoa(int(replica)),
-					"--enable-flush", "1", "--wait",
-				}
-				if bucket.Sasl != "" {
-					command = append(command, "--bucket-password", bucket.Sasl)
-				}
-				if bucket.Eviction != "" {
-					command = append(command, "--bucket-eviction-policy", bucket.Eviction)
-				}
-
-				desc := "bucket create " + bucketName
-				task := ContainerTask{
-					Describe: desc,
-					Image:    image,
-					Command:  command,
-					Async:    false,
-				}
-				if s.Provider.GetType() == "docker" {
-					task.LinksTo = orchestrator
-				}
-
-				s.Cm.Run(&task)
+				s.CreateBucket(server, bucket, bucketName)
 			}
 		}
 	}
@@ -469,6 +453,70 @@ func (s *Scope) CreateBuckets() {
 
 }
 
+// bucket ram in MB, percentages are of server ram
+func BucketRamQuota(server *ServerSpec, bucket BucketSpec) string {
+	ramQuota := bucket.Ram
+	if strings.Index(ramQuota, "%") > -1 {
+		// convert bucket ram to value within context of server ram
+		ramQuota = strings.Replace(ramQuota, "%", "", 1)
+		ramVal, _ := strconv.Atoi(ramQuota)
+		nodeRam, _ := strconv.Atoi(server.Ram)
+		ramQuota = strconv.Itoa((nodeRam * ramVal) / 100)
+	}
+	return ramQuota
+}
+
+func BucketReplica(bucket BucketSpec) string {
+	var replica uint8 = 1
+	if bucket.Replica != nil {
+		replica = *bucket.Replica
+	}
+	return strconv.Itoa(int(replica))
+}
+
+func (s *Scope) CreateBucket(server *ServerSpec, bucket BucketSpec, bucketName string) {
+
+	var image = "sequoiatools/couchbase-cli"
+
+	orchestrator := server.Names[0]
+	ip := s.Provider.GetHostAddress(orchestrator)
+
+	command := []string{"bucket-create", "-c", ip,
+		"-u", server.RestUsername, "-p", server.RestPassword,
+		"--bucket", bucketName,
+		"--bucket-ramsize", BucketRamQuota(server, bucket),
+		"--bucket-type", bucket.Type,
+		"--bucket-replica", BucketReplica(bucket),
+		"--wait",
+	}
+	if bucket.Sasl != "" {
+		command = append(command, "--bucket-password", bucket.Sasl)
+	}
+
+	// settings supported by server version
+	settings, err := BucketCreateArgs(bucket, s.Version)
+	if err != nil {
+		msg := UtilTaskMsg("[bucket create]", fmt.Sprintf("%s: %s", bucketName, err))
+		ecolorsay(msg)
+		s.Cm.TapHandle.Ok(false, msg)
+		return
+	}
+	command = append(command, settings...)
+
+	desc := "bucket create " + bucketName
+	task := ContainerTask{
+		Describe: desc,
+		Image:    image,
+		Command:  command,
+		Async:    false,
+	}
+	if s.Provider.GetType() == "docker" {
+		task.LinksTo = orchestrator
+	}
+
+	s.Cm.Run(&task)
+}
+
 func (s *Scope) GetPercOfMemTotal(name string, server *ServerSpec, quota string) string {
 	memTotal := s.ClusterMemTotal(name, server)
 	ramQuota := strings.Replace(quota, "%", "", 1)
@@ -501,14 +549,45 @@ func (s *Scope) ClusterIndexQuota(name string, server *ServerSpec) int {
 	return mem
 }
 
+// run couchbase-cli command against cluster orchestrator,
+// returns error of a non-zero exit which is also reported
+// as failed test point
+func (s *Scope) RunCliTask(desc string, command []string, orchestrator string) error {
+	task := ContainerTask{
+		Describe: desc,
+		Image:    "sequoiatools/couchbase-cli",
+		Command:  command,
+		Async:    false,
+	}
+	if s.Provider.GetType() == "docker" {
+		task.LinksTo = orchestrator
+	}
+	_, echan := s.Cm.Run(&task)
+	if err := <-echan; err != nil {
+		ecolorsay(fmt.Sprintf("%s failed with exit status %s", desc, err))
+		return err
+	}
+	return nil
+}
+
 func (s *Scope) CompileCommand(actionCommand string) []string {
+	command, err := s.RenderCommand(actionCommand)
+	logerr(err)
+	return command
+}
+
+// compile command returning any template error
+func (s *Scope) RenderCommand(actionCommand string) ([]string, error) {
 
 	// remove extraneous white space
 	re := regexp.MustCompile(`\s+`)
 	actionCommand = re.ReplaceAllString(actionCommand, " ")
 
 	// parse template
-	actionCommand = ParseTemplate(s, actionCommand)
+	actionCommand, err := RenderTemplate(s, actionCommand)
+	if err != nil {
+		return nil, err
+	}
 
 	// translate into in slice
 	command := strings.Split(actionCommand, " ")
@@ -541,7 +620,7 @@ func (s *Scope) CompileCommand(actionCommand string) []string {
 		}
 	}
 
-	return commandFinal
+	return commandFinal, nil
 }
 
 //
@@ -569,6 +648,16 @@ func cliCommandValidator(version string, command []string) []string {
 			continue
 		}
 
+		// <6.0 builds
+		if vMajor < 6.0 && arg == "--cluster-analytics-ramsize" {
+			continue
+		}
+
+		// <5.5 builds
+		if vMajor < 5.5 && arg == "--cluster-eventing-ramsize" {
+			continue
+		}
+
 		// <4.5 builds
 		if vMajor < 4.5 {
 			if arg == "--index-storage-setting" ||
@@ -588,7 +677,11 @@ func cliCommandValidator(version string, command []string) []string {
 		result = append(result, arg)
 		// check if arg has value
 		if i+1 < len(command) {
-			result = append(result, command[i+1])
+			val := command[i+1]
+			if arg == "--services" {
+				val = ServicesForVersion(val, vMajor)
+			}
+			result = append(result, val)
 		}
 	}
 
@@ -647,34 +740,11 @@ func (s *Scope) CreateViews() {
 
 func (s *Scope) DeleteBuckets() {
 
-	var image = "sequoiatools/couchbase-cli"
-
 	// configure rebalance operation
 	operation := func(name string, server *ServerSpec) {
-
-		orchestrator := server.Names[0]
-		ip := s.Provider.GetHostAddress(orchestrator)
-
 		for _, bucket := range server.BucketSpecs {
 			for _, bucketName := range bucket.Names {
-
-				command := []string{"bucket-delete", "-c", ip,
-					"-u", server.RestUsername, "-p", server.RestPassword,
-					"--bucket", bucketName,
-				}
-
-				desc := "bucket delete" + bucketName
-				task := ContainerTask{
-					Describe: desc,
-					Image:    image,
-					Command:  command,
-					Async:    false,
-				}
-				if s.Provider.GetType() == "docker" {
-					task.LinksTo = orchestrator
-				}
-
-				s.Cm.Run(&task)
+				s.DeleteBucket(server, bucketName)
 			}
 		}
 	}
@@ -684,45 +754,65 @@ func (s *Scope) DeleteBuckets() {
 
 }
 
-func (s *Scope) RemoveNodes() {
+func (s *Scope) DeleteBucket(server *ServerSpec, bucketName string) {
 
 	var image = "sequoiatools/couchbase-cli"
 
-	rmNodesOp := func(name string, server *ServerSpec) {
+	orchestrator := server.Names[0]
+	ip := s.Provider.GetHostAddress(orchestrator)
+
+	command := []string{"bucket-delete", "-c", ip,
+		"-u", server.RestUsername, "-p", server.RestPassword,
+		"--bucket", bucketName,
+	}
+
+	desc := "bucket delete" + bucketName
+	task := ContainerTask{
+		Describe: desc,
+		Image:    image,
+		Command:  command,
+		Async:    false,
+	}
+	if s.Provider.GetType() == "docker" {
+		task.LinksTo = orchestrator
+	}
+
+	s.Cm.Run(&task)
+}
+
+// rebalance out all active nodes other than orchestrator
+func (s *Scope) RemoveNodes() {
+
+	s.Topology.Invalidate()
+
+	operation := func(name string, server *ServerSpec) {
 
 		orchestrator := server.Names[0]
 		orchestratorIp := s.Provider.GetHostAddress(orchestrator)
-		ip := s.Provider.GetHostAddress(name)
 
-		if name == orchestrator {
-			return // not removing self
+		ips := []string{}
+		for _, nodeName := range server.Names[1:] {
+			if s.NodeIsActive(nodeName) == true {
+				ips = append(ips, s.Provider.GetHostAddress(nodeName))
+			}
+		}
+		if len(ips) == 0 {
+			return
 		}
 
 		command := []string{"rebalance",
 			"-c", orchestratorIp,
 			"-u", server.RestUsername,
 			"-p", server.RestPassword,
-			"--server-remove", ip,
+			"--server-remove", strings.Join(ips, ","),
 		}
-
-		desc := "remove node " + ip
 		command = cliCommandValidator(s.Version, command)
-
-		task := ContainerTask{
-			Describe: desc,
-			Image:    image,
-			Command:  command,
-			Async:    false,
-		}
-		if s.Provider.GetType() == "docker" {
-			task.LinksTo = orchestrator
-		}
-
-		s.Cm.Run(&task)
+		s.RunCliTask("remove nodes "+strings.Join(ips, ","), command, orchestrator)
 	}
 
-	// add nodes
-	s.Spec.ApplyToAllServers(rmNodesOp)
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+	s.Topology.Invalidate()
 }
 
 func (s *Scope) GetPlatform() string {
@@ -740,3 +830,11 @@ func (s *Scope) GetVarsKV(key string) (string, bool) {
 		return "", false
 	}
 }
+
+func (s *Scope) SetCapturedVar(key string, val interface{}) {
+	s.Captured.Set(key, val)
+}
+
+func (s *Scope) GetCapturedVar(key string) (interface{}, bool) {
+	return s.Captured.Get(key)
+}
@@ -0,0 +1,189 @@
+package sequoia
+
+/* Settings.go
+ *
+ * Converts cluster settings of server spec to
+ * couchbase-cli setting-* commands, applied after
+ * cluster init and re-applied after scope transitions
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var SettingAlerts = []string{
+	"auto-failover-node", "auto-failover-max-reached", "auto-failover-node-down",
+	"auto-failover-cluster-small", "auto-failover-disable", "ip-changed",
+	"disk-space", "meta-overhead", "meta-oom", "write-failure",
+	"audit-msg-dropped", "indexer-ram-max-usage", "ep-clock-cas-drift-threshold",
+	"communication-issue",
+}
+
+type settingFlag struct {
+	Flag  string
+	Value string
+}
+
+// appends flags that have a value
+func appendSettingFlags(command []string, flags []settingFlag) []string {
+	for _, f := range flags {
+		if f.Value != "" {
+			command = append(command, f.Flag, f.Value)
+		}
+	}
+	return command
+}
+
+func boolSetting(b *bool) string {
+	if b == nil {
+		return ""
+	}
+	if *b == true {
+		return "1"
+	}
+	return "0"
+}
+
+// setting-* commands (without connection args) for settings
+// supported by version, unsupported settings are skipped
+func SettingsCommands(settings SettingsSpec, version string) ([][]string, error) {
+
+	commands := [][]string{}
+	vMajor, _ := strconv.ParseFloat(version, 64)
+	supported := func(name string, min float64) bool {
+		if vMajor > 0 && vMajor < min {
+			ecolorsay(fmt.Sprintf("settings %s requires version %.1f, skipping", name, min))
+			return false
+		}
+		return true
+	}
+
+	if af := settings.AutoFailover; af != nil {
+		enabled := boolSetting(af.Enabled)
+		if enabled == "" {
+			enabled = "1"
+		}
+		command := appendSettingFlags([]string{"setting-autofailover"}, []settingFlag{
+			{"--enable-auto-failover", enabled},
+			{"--auto-failover-timeout", af.Timeout},
+		})
+		if af.MaxCount != "" && supported("auto_failover.max_count", 5.5) {
+			command = append(command, "--max-failovers", af.MaxCount)
+		}
+		commands = append(commands, command)
+	}
+
+	if c := settings.Compaction; c != nil {
+		command := appendSettingFlags([]string{"setting-compaction"}, []settingFlag{
+			{"--compaction-db-percentage", c.DbPercentage},
+			{"--compaction-view-percentage", c.ViewPercentage},
+			{"--metadata-purge-interval", c.PurgeInterval},
+			{"--enable-compaction-parallel", boolSetting(c.Parallel)},
+		})
+		commands = append(commands, command)
+	}
+
+	if i := settings.Index; i != nil && supported("index", 4.0) {
+		command := appendSettingFlags([]string{"setting-index"}, []settingFlag{
+			{"--index-storage-setting", i.StorageMode},
+			{"--index-threads", i.Threads},
+			{"--index-max-rollback-points", i.MaxRollbackPoints},
+			{"--index-log-level", i.LogLevel},
+		})
+		commands = append(commands, command)
+	}
+
+	if q := settings.Query; q != nil && supported("query", 7.0) {
+		command := appendSettingFlags([]string{"setting-query"}, []settingFlag{
+			{"--timeout", q.Timeout},
+			{"--pipeline-batch", q.PipelineBatch},
+			{"--pipeline-cap", q.PipelineCap},
+			{"--scan-cap", q.ScanCap},
+			{"--prepared-limit", q.PreparedLimit},
+			{"--completed-limit", q.CompletedLimit},
+			{"--completed-threshold", q.CompletedThreshold},
+			{"--max-parallelism", q.MaxParallelism},
+			{"--temp-dir-size", q.TmpSpaceSize},
+			{"--log-level", q.LogLevel},
+		})
+		commands = append(commands, command)
+	}
+
+	if a := settings.Alerts; a != nil {
+		command := appendSettingFlags([]string{"setting-alert",
+			"--enable-email-alert", "1"}, []settingFlag{
+			{"--email-recipients", strings.Join(a.Recipients, ",")},
+			{"--email-sender", a.Sender},
+			{"--email-host", a.Host},
+			{"--email-port", a.Port},
+			{"--email-user", a.User},
+			{"--email-password", a.Password},
+		})
+		for _, alert := range a.Alerts {
+			if InList(alert, SettingAlerts) == false {
+				return commands, fmt.Errorf("settings alerts: invalid alert %q, expected one of %v",
+					alert, SettingAlerts)
+			}
+			command = append(command, "--alert-"+alert)
+		}
+		commands = append(commands, command)
+	}
+
+	return commands, nil
+}
+
+// applies settings of each cluster and records
+// them for re-applying after scope transitions
+func (s *Scope) ApplySettings() {
+
+	operation := func(name string, server *ServerSpec) {
+		if server.Settings == nil {
+			return
+		}
+		s.applyClusterSettings(name, server, *server.Settings)
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
+
+// re-applies recorded settings to clusters
+// of current spec that do not define settings
+func (s *Scope) ReapplySettings() {
+
+	operation := func(name string, server *ServerSpec) {
+		if server.Settings != nil {
+			return
+		}
+		if settings, ok := s.Settings[server.Name]; ok == true {
+			colorsay("re-applying settings of cluster " + server.Name)
+			s.applyClusterSettings(name, server, settings)
+		}
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
+
+func (s *Scope) applyClusterSettings(name string, server *ServerSpec, settings SettingsSpec) {
+
+	orchestrator := server.Names[0]
+	ip := s.Provider.GetHostAddress(orchestrator)
+
+	commands, err := SettingsCommands(settings, s.Version)
+	if err != nil {
+		// settings are validated with spec
+		ecolorsay(fmt.Sprintf("settings of cluster %s: %s", server.Name, err))
+		return
+	}
+	for _, command := range commands {
+		args := []string{command[0], "-c", ip,
+			"-u", server.RestUsername, "-p", server.RestPassword,
+		}
+		args = append(args, command[1:]...)
+		s.RunCliTask("settings "+command[0]+" "+server.Name, args, orchestrator)
+	}
+	s.Settings[server.Name] = settings
+}
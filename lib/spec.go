@@ -2,20 +2,46 @@ package sequoia
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 type BucketSpec struct {
-	Name      string
-	Names     []string
-	Count     uint8
-	Ram       string
-	Replica   *uint8
-	Type      string
-	Sasl      string
-	Eviction  string
-	DDocs     string
-	DDocSpecs []DDocSpec
+	Name               string
+	Names              []string
+	Count              uint8
+	Ram                string
+	Replica            *uint8
+	Type               string
+	Sasl               string
+	Eviction           string
+	ConflictResolution string `yaml:"conflict_resolution"`
+	Compression        string
+	MaxTTL             *int `yaml:"max_ttl"`
+	Durability         string
+	Priority           string
+	Flush              *bool
+	Scopes             []BucketScopeSpec
+	DDocs              string
+	DDocSpecs          []DDocSpec
+	IndexSpecs         []IndexSpec
+}
+
+// scope within a bucket, named
+// with count like buckets
+type BucketScopeSpec struct {
+	Name        string
+	Names       []string
+	Count       uint8
+	Collections []CollectionSpec
+}
+
+type CollectionSpec struct {
+	Name   string
+	Names  []string
+	Count  uint8
+	MaxTTL *int `yaml:"max_ttl"`
 }
 
 type ServerSpec struct {
@@ -26,6 +52,8 @@ type ServerSpec struct {
 	Ram          string
 	IndexRam     string `yaml:"index_ram"`
 	FtsRam       string `yaml:"fts_ram"`
+	EventingRam  string `yaml:"eventing_ram"`
+	AnalyticsRam string `yaml:"analytics_ram"`
 	RestUsername string `yaml:"rest_username"`
 	RestPassword string `yaml:"rest_password"`
 	SSHUsername  string `yaml:"ssh_username"`
@@ -42,6 +70,20 @@ type ServerSpec struct {
 	NodesActive  uint8
 	Services     map[string]uint8
 	NodeServices map[string][]string
+	Groups       map[string]interface{}
+	NodeGroups   map[string]string
+	Settings     *SettingsSpec
+	Nodes        []NodeSpec
+}
+
+// explicit node of server spec by 1-based index with
+// services replacing count based placement and overrides
+type NodeSpec struct {
+	Node      int
+	Services  string
+	Group     string
+	DataPath  string `yaml:"data_path"`
+	IndexPath string `yaml:"index_path"`
 }
 
 type ViewSpec struct {
@@ -56,80 +98,320 @@ type DDocSpec struct {
 	ViewSpecs []ViewSpec
 }
 
+// cluster wide settings applied after cluster init,
+// values are passed as is to couchbase-cli setting-* commands.
+// service quotas are set by the ram fields of server spec
+type SettingsSpec struct {
+	AutoFailover *AutoFailoverSpec `yaml:"auto_failover"`
+	Compaction   *CompactionSpec
+	Index        *IndexSettingsSpec
+	Query        *QuerySettingsSpec
+	Alerts       *AlertSpec
+}
+
+type AutoFailoverSpec struct {
+	Enabled  *bool
+	Timeout  string
+	MaxCount string `yaml:"max_count"`
+}
+
+type CompactionSpec struct {
+	DbPercentage   string `yaml:"db_percentage"`
+	ViewPercentage string `yaml:"view_percentage"`
+	PurgeInterval  string `yaml:"purge_interval"`
+	Parallel       *bool
+}
+
+type IndexSettingsSpec struct {
+	StorageMode       string `yaml:"storage_mode"`
+	Threads           string
+	MaxRollbackPoints string `yaml:"max_rollback_points"`
+	LogLevel          string `yaml:"log_level"`
+}
+
+type QuerySettingsSpec struct {
+	Timeout            string
+	PipelineBatch      string `yaml:"pipeline_batch"`
+	PipelineCap        string `yaml:"pipeline_cap"`
+	ScanCap            string `yaml:"scan_cap"`
+	PreparedLimit      string `yaml:"prepared_limit"`
+	CompletedLimit     string `yaml:"completed_limit"`
+	CompletedThreshold string `yaml:"completed_threshold"`
+	MaxParallelism     string `yaml:"max_parallelism"`
+	TmpSpaceSize       string `yaml:"tmp_space_size"`
+	LogLevel           string `yaml:"log_level"`
+}
+
+// email alerts, alert names as in setting-alert
+// without prefix, ie.. auto-failover-node, disk-space
+type AlertSpec struct {
+	Recipients []string
+	Sender     string
+	Host       string
+	Port       string
+	User       string
+	Password   string
+	Alerts     []string
+}
+
+// gsi index on bucket (or collection) where bucket
+// is either the bucket spec name or an expanded name
+type IndexSpec struct {
+	Name         string
+	Bucket       string
+	Scope        string
+	Collection   string
+	Fields       string
+	Where        string
+	Primary      bool
+	PartitionBy  string `yaml:"partition_by"`
+	NumPartition int    `yaml:"num_partition"`
+	Replicas     int
+	Nodes        string
+	DeferBuild   bool `yaml:"defer_build"`
+}
+
+// full text index on bucket with definition from
+// file or inline, params replace definition params
+type FtsIndexSpec struct {
+	Name       string
+	Bucket     string
+	File       string
+	Definition map[string]interface{}
+	Params     map[string]interface{}
+	Partitions int
+	Replicas   int
+}
+
+// rbac user created on clusters during setup, roles
+// are scoped to buckets as role[bucket], ie.. data_reader[default]
+type UserSpec struct {
+	Name     string
+	Username string
+	Password string
+	Roles    []string
+	Clusters string
+}
+
+type XdcrSpec struct {
+	Remotes      []RemoteSpec
+	Replications []ReplicationSpec
+}
+
+// reference from cluster to a remote cluster
+type RemoteSpec struct {
+	Name string
+	From string
+	To   string
+}
+
+// replication of buckets from source cluster to target,
+// bucket defaults to all buckets of the source cluster
+type ReplicationSpec struct {
+	From               string
+	To                 string
+	Remote             string
+	Bucket             string
+	ToBucket           string `yaml:"to_bucket"`
+	Filter             string
+	ConflictResolution string `yaml:"conflict_resolution"`
+	Bidirectional      bool
+}
+
 type ScopeSpec struct {
-	Buckets []BucketSpec
-	Servers []ServerSpec
-	Views   []ViewSpec
-	DDocs   []DDocSpec `yaml:"ddocs"`
+	Buckets    []BucketSpec
+	Servers    []ServerSpec
+	Views      []ViewSpec
+	DDocs      []DDocSpec `yaml:"ddocs"`
+	Indexes    []IndexSpec
+	FtsIndexes []FtsIndexSpec `yaml:"fts_indexes"`
+	Users      []UserSpec
+	Xdcr       XdcrSpec
+	Vars       map[string]string
 }
 
-func (s *ServerSpec) InitNodeServices() {
+// minimum server version of services added after 4.0
+var ServiceMinVersion = map[string]float64{
+	"eventing":  5.5,
+	"analytics": 6.0,
+	"backup":    7.0,
+}
 
-	var i uint8
-	numNodes := s.Count
-	numIndexNodes := s.Services["index"]
-	numQueryNodes := s.Services["query"]
-	numFtsNodes := s.Services["fts"]
-	numDataNodes := s.Services["data"]
-	customIndexStart := s.Services["index_start"]
-	customQueryStart := s.Services["query_start"]
-	customFtsStart := s.Services["fts_start"]
+// comma separated services supported by version,
+// unsupported services are skipped
+func ServicesForVersion(services string, vMajor float64) string {
+	supported := []string{}
+	for _, service := range CommaStrToList(services) {
+		if min, ok := ServiceMinVersion[service]; ok == true && vMajor < min {
+			ecolorsay(fmt.Sprintf("service %s requires version %.1f, skipping", service, min))
+			continue
+		}
+		supported = append(supported, service)
+	}
+	return strings.Join(supported, ",")
+}
 
-	s.NodeServices = make(map[string][]string)
+// each node must keep a service supported by version
+// so that errors are found before provisioning
+func ValidateServiceSpecs(spec ScopeSpec, version string) error {
+	vMajor, _ := strconv.ParseFloat(version, 64)
+	if vMajor < 4.0 {
+		// services are not set before 4.0
+		return nil
+	}
+	for _, server := range spec.Servers {
+		for _, name := range server.Names {
+			supported := false
+			for _, service := range server.NodeServices[name] {
+				if min, ok := ServiceMinVersion[service]; ok == false || vMajor >= min {
+					supported = true
+				}
+			}
+			if supported == false {
+				return fmt.Errorf("cluster %s: node %s has no services supported by version %s: %s",
+					server.Name, name, version, strings.Join(server.NodeServices[name], ","))
+			}
+		}
+	}
+	return nil
+}
 
-	// Spread Strategy
-	// make first set of nodes data
-	// and second set index to avoid
-	// overlapping if possible when specific
-	// number of service types provided
-	indexStartPos := numNodes - numQueryNodes - numIndexNodes
-	if customIndexStart > 0 {
-		// override
-		indexStartPos = customIndexStart - 1
+// services placed in order of list when multiple services
+// share a node, other service names follow in sorted order
+var ServicePlacementOrder = []string{"index", "fts", "query", "eventing", "analytics", "backup"}
+
+// service names counted in services map, ie..
+// {index: 1, index_start: 3} counts only index
+func (s *ServerSpec) CountedServices() []string {
+	services := []string{}
+	for _, service := range ServicePlacementOrder {
+		if _, ok := s.Services[service]; ok == true {
+			services = append(services, service)
+		}
+	}
+	others := []string{}
+	for service, _ := range s.Services {
+		if service == "data" || strings.HasSuffix(service, "_start") ||
+			InList(service, ServicePlacementOrder) {
+			continue
+		}
+		others = append(others, service)
 	}
-	if indexStartPos > numNodes {
-		indexStartPos = 0
+	sort.Strings(others)
+	return append(services, others...)
+}
+
+// explicit node entry for node name
+func (s *ServerSpec) NodeSpecByName(name string) (NodeSpec, bool) {
+	for _, node := range s.Nodes {
+		if node.Node > 0 && node.Node <= len(s.Names) && s.Names[node.Node-1] == name {
+			return node, true
+		}
 	}
+	return NodeSpec{}, false
+}
 
-	// fts defaults on same box as index machine
-	// override with fts_start
-	ftsStartPos := indexStartPos
-	if customFtsStart > 0 {
-		// override
-		ftsStartPos = customFtsStart - 1
+func (s *ServerSpec) NodeDataPath(name string) string {
+	if node, ok := s.NodeSpecByName(name); ok && node.DataPath != "" {
+		return node.DataPath
 	}
-	if ftsStartPos > numNodes {
-		ftsStartPos = 0
+	return s.DataPath
+}
+
+func (s *ServerSpec) NodeIndexPath(name string) string {
+	if node, ok := s.NodeSpecByName(name); ok && node.IndexPath != "" {
+		return node.IndexPath
 	}
+	return s.IndexPath
+}
+
+func (s *ServerSpec) InitNodeServices() error {
 
-	queryStartPos := numNodes - numQueryNodes
-	if customQueryStart > 0 {
-		// override
-		queryStartPos = customQueryStart - 1
+	numNodes := int(s.Count)
+	if numNodes > len(s.Names) {
+		numNodes = len(s.Names)
 	}
-	if queryStartPos > numNodes {
-		queryStartPos = 0
+
+	s.NodeServices = make(map[string][]string)
+
+	// explicit nodes, default to position in list
+	explicit := make(map[string]bool)
+	for j, node := range s.Nodes {
+		if node.Node == 0 {
+			node.Node = j + 1
+			s.Nodes[j].Node = node.Node
+		}
+		if node.Node < 0 || node.Node > numNodes {
+			return fmt.Errorf("servers %s: node %d out of range", s.Name, node.Node)
+		}
+		if node.Services == "" {
+			continue
+		}
+		name := s.Names[node.Node-1]
+		for _, service := range CommaStrToList(node.Services) {
+			s.NodeServices[name] = append(s.NodeServices[name], SpecServiceName(service))
+		}
+		explicit[name] = true
 	}
 
-	for i = 0; i < numNodes; i = i + 1 {
-		name := s.Names[i]
-		s.NodeServices[name] = []string{}
-		if i >= indexStartPos && numIndexNodes > 0 {
-			s.NodeServices[name] = append(s.NodeServices[name], "index")
-			numIndexNodes--
+	// remaining nodes are placed by count
+	names := []string{}
+	for _, name := range s.Names[:numNodes] {
+		if explicit[name] == false {
+			names = append(names, name)
 		}
-		if i >= ftsStartPos && numFtsNodes > 0 {
-			s.NodeServices[name] = append(s.NodeServices[name], "fts")
-			numFtsNodes--
+	}
+	numNodes = len(names)
+
+	// Spread Strategy
+	// make first set of nodes data
+	// and second set index to avoid
+	// overlapping if possible when specific
+	// number of service types provided,
+	// query takes the last nodes and index
+	// the nodes before query, other services
+	// stack before index
+	remaining := make(map[string]int)
+	counted := s.CountedServices()
+	for _, service := range counted {
+		remaining[service] = int(s.Services[service])
+	}
+	startPos := make(map[string]int)
+	setStart := func(service string, pos int) {
+		if customStart := int(s.Services[service+"_start"]); customStart > 0 {
+			// override
+			pos = customStart - 1
+		}
+		if pos < 0 || pos > numNodes {
+			pos = 0
+		}
+		startPos[service] = pos
+	}
+	setStart("index", numNodes-remaining["query"]-remaining["index"])
+	// fts defaults on same box as index machine
+	setStart("fts", startPos["index"])
+	setStart("query", numNodes-remaining["query"])
+	stackPos := startPos["index"]
+	for _, service := range counted {
+		if _, ok := startPos[service]; ok == false {
+			stackPos -= remaining[service]
+			setStart(service, stackPos)
 		}
-		if i >= queryStartPos && numQueryNodes > 0 {
-			s.NodeServices[name] = append(s.NodeServices[name], "query")
-			numQueryNodes--
+	}
+	numDataNodes := int(s.Services["data"])
+
+	for i, name := range names {
+		s.NodeServices[name] = []string{}
+		for _, service := range counted {
+			if i >= startPos[service] && remaining[service] > 0 {
+				s.NodeServices[name] = append(s.NodeServices[name], service)
+				remaining[service]--
+			}
 		}
 		if numDataNodes > 0 {
 			s.NodeServices[name] = append(s.NodeServices[name], "data")
 			numDataNodes--
-		} else if i == 0 { // must add data to orchestrator
+		} else if name == s.Names[0] { // must add data to orchestrator
 			s.NodeServices[name] = append(s.NodeServices[name], "data")
 		}
 
@@ -138,6 +420,7 @@ func (s *ServerSpec) InitNodeServices() {
 			s.NodeServices[name] = append(s.NodeServices[name], "data")
 		}
 	}
+	return nil
 }
 
 func (s *ScopeSpec) ApplyToAllServers(operation func(string, *ServerSpec)) {
@@ -152,7 +435,8 @@ func (s *ScopeSpec) ApplyToAllServersAsync(operation func(string, *ServerSpec, c
 
 		for _, serverName := range server.Names[:endIdx] {
 			c := make(chan bool)
-			go operation(serverName, &server, c)
+			name, srv := serverName, &server
+			GoRecover(func() { operation(name, srv, c) })
 			waitChans = append(waitChans, c)
 			s.Servers[i] = server // allowed apply func to modify server
 		}
@@ -209,6 +493,16 @@ func (s *ScopeSpec) ToAttr(attr string) string {
 	return ""
 }
 
+// user by name, ie.. {{.User `reader`}}
+func (s *ScopeSpec) ForUser(name string) (UserSpec, bool) {
+	for _, user := range s.Users {
+		if user.Name == name {
+			return user, true
+		}
+	}
+	return UserSpec{}, false
+}
+
 func (s *ScopeSpec) ForCluster(name string) ServerSpec {
 	var spec ServerSpec
 	for _, cluster := range s.Servers {
@@ -221,24 +515,29 @@ func (s *ScopeSpec) ForCluster(name string) ServerSpec {
 }
 
 func NewScopeSpec(fileName string) ScopeSpec {
+	return NewScopeSpecWithVars(fileName, nil, 1)
+}
+
+// scope spec with vars and scale factor
+// resolved in scope templates
+func NewScopeSpecWithVars(fileName string, vars map[string]string, scale int) ScopeSpec {
 
 	var spec ScopeSpec
 	if strings.Index(fileName, ".ini") > 0 {
 		spec = SpecFromIni(fileName)
+		spec.Vars = make(map[string]string)
+		for k, v := range vars {
+			spec.Vars[k] = v
+		}
 	} else {
-		spec = SpecFromYaml(fileName)
+		spec = SpecFromComposedYaml(fileName, vars, scale)
 	}
 
 	return spec
 }
 
 func SpecFromYaml(fileName string) ScopeSpec {
-	var spec ScopeSpec
-
-	// init from yaml
-	ReadYamlFile(fileName, &spec)
-	ConfigureSpec(&spec)
-	return spec
+	return SpecFromComposedYaml(fileName, nil, 1)
 }
 
 func ConfigureSpec(spec *ScopeSpec) {
@@ -251,6 +550,7 @@ func ConfigureSpec(spec *ScopeSpec) {
 	// map ddocs to views
 	ddocNameMap := make(map[string]DDocSpec)
 	for i, ddoc := range spec.DDocs {
+		spec.DDocs[i].ViewSpecs = nil
 		for _, viewName := range CommaStrToList(ddoc.Views) {
 			if view, ok := viewNameMap[viewName]; ok == true {
 				spec.DDocs[i].ViewSpecs = append(spec.DDocs[i].ViewSpecs, view)
@@ -263,10 +563,28 @@ func ConfigureSpec(spec *ScopeSpec) {
 	bucketNameMap := make(map[string]BucketSpec)
 	for i, bucket := range spec.Buckets {
 		spec.Buckets[i].Names = ExpandBucketName(bucket.Name, bucket.Count, 1)
+		spec.Buckets[i].IndexSpecs = nil
+		spec.Buckets[i].DDocSpecs = nil
 		if spec.Buckets[i].Type == "" {
 			spec.Buckets[i].Type = "couchbase"
 		}
 
+		// expand scopes and collections
+		for j, scope := range bucket.Scopes {
+			spec.Buckets[i].Scopes[j].Names = ExpandBucketName(scope.Name, scope.Count, 1)
+			for k, collection := range scope.Collections {
+				spec.Buckets[i].Scopes[j].Collections[k].Names =
+					ExpandBucketName(collection.Name, collection.Count, 1)
+			}
+		}
+
+		// map indexes to bucket
+		for _, index := range spec.Indexes {
+			if index.Bucket == bucket.Name || InList(index.Bucket, spec.Buckets[i].Names) {
+				spec.Buckets[i].IndexSpecs = append(spec.Buckets[i].IndexSpecs, index)
+			}
+		}
+
 		if bucket.DDocs != "" {
 			ddocNames := CommaStrToList(bucket.DDocs)
 			for _, ddocName := range ddocNames {
@@ -291,9 +609,117 @@ func ConfigureSpec(spec *ScopeSpec) {
 			}
 		}
 		// init node services
-		spec.Servers[i].InitNodeServices()
+		logerr(spec.Servers[i].InitNodeServices())
+
+		// assign nodes to server groups
+		logerr(spec.Servers[i].InitNodeGroups())
+
+		// settings must convert to cli commands
+		if server.Settings != nil {
+			if _, err := SettingsCommands(*server.Settings, ""); err != nil {
+				logerrstr(fmt.Sprintf("invalid settings of cluster %s: %s", server.Name, err))
+			}
+		}
 	}
 
+	// users are referenced by name or username
+	for i, user := range spec.Users {
+		if user.Username == "" {
+			spec.Users[i].Username = user.Name
+		}
+		if user.Name == "" {
+			spec.Users[i].Name = user.Username
+		}
+	}
+
+	// fts indexes must be on a known bucket
+	for _, index := range spec.FtsIndexes {
+		_, _, err := spec.FtsIndexBucket(index)
+		logerr(err)
+	}
+
+	// replications must map onto buckets of source cluster
+	for _, repl := range spec.Xdcr.AllReplications() {
+		_, err := ReplicationBuckets(repl, spec.ForCluster(repl.From))
+		logerr(err)
+	}
+
+	// replicated buckets require same conflict resolution
+	for _, repl := range spec.Xdcr.Replications {
+		if repl.ConflictResolution == "" {
+			continue
+		}
+		for i, server := range spec.Servers {
+			if server.Name != repl.From && server.Name != repl.To {
+				continue
+			}
+			replBuckets := CommaStrToList(repl.Bucket + "," + repl.ToBucket)
+			for j, bucket := range server.BucketSpecs {
+				names := append([]string{bucket.Name}, bucket.Names...)
+				if repl.Bucket == "" || ListsIntersect(names, replBuckets) {
+					spec.Servers[i].BucketSpecs[j].ConflictResolution = repl.ConflictResolution
+				}
+			}
+		}
+	}
+
+}
+
+// remote references needed by replications,
+// defaults to remote named after target cluster
+func (x *XdcrSpec) AllRemotes() []RemoteSpec {
+	remotes := []RemoteSpec{}
+	seen := make(map[string]bool)
+	add := func(remote RemoteSpec) {
+		if remote.Name == "" {
+			remote.Name = remote.To
+		}
+		key := remote.From + "/" + remote.Name
+		if seen[key] == false {
+			seen[key] = true
+			remotes = append(remotes, remote)
+		}
+	}
+
+	for _, remote := range x.Remotes {
+		add(remote)
+	}
+	for _, repl := range x.AllReplications() {
+		add(RemoteSpec{Name: repl.Remote, From: repl.From, To: repl.To})
+	}
+	return remotes
+}
+
+// replications with reverse of bidirectional replications
+func (x *XdcrSpec) AllReplications() []ReplicationSpec {
+	replications := []ReplicationSpec{}
+	for _, repl := range x.Replications {
+		if repl.Remote == "" {
+			repl.Remote = x.RemoteName(repl.From, repl.To)
+		}
+		replications = append(replications, repl)
+		if repl.Bidirectional == true {
+			reverse := repl
+			reverse.From, reverse.To = repl.To, repl.From
+			reverse.Remote = x.RemoteName(repl.To, repl.From)
+			if repl.ToBucket != "" {
+				reverse.Bucket, reverse.ToBucket = repl.ToBucket, repl.Bucket
+			}
+			reverse.Bidirectional = false
+			replications = append(replications, reverse)
+		}
+	}
+	return replications
+}
+
+// name of declared remote reference between clusters
+func (x *XdcrSpec) RemoteName(from, to string) string {
+	for _, remote := range x.Remotes {
+		if remote.From == from && remote.To == to && remote.Name != "" {
+			return remote.Name
+		}
+	}
+	return to
 }
 
 // some common defaults when not defined in yaml scope
@@ -354,10 +780,10 @@ func SpecFromIni(fileName string) ScopeSpec {
 		}
 
 		if services := section.Key("services"); services.String() != "" {
-			svcString := services.String()
-			svcString = strings.Replace(svcString, "kv", "data", 1)
-			svcString = strings.Replace(svcString, "n1ql", "query", 1)
-			serverSpec.NodeServices[name] = CommaStrToList(svcString)
+			for _, service := range CommaStrToList(services.String()) {
+				serverSpec.NodeServices[name] = append(serverSpec.NodeServices[name],
+					SpecServiceName(service))
+			}
 		}
 		serverSpec.Ram = "60%"
 	}
@@ -0,0 +1,146 @@
+package sequoia
+
+/* State.go
+ *
+ * Run state is saved after each test action so that
+ * an interrupted test can be resumed from the next action.
+ * Nested tests push a frame on the state of the top-level
+ * test, which is the only one to create, resume or remove it
+ */
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type RunState struct {
+	File       string `yaml:"-"`
+	Run        string
+	Scope      string
+	Spec       *ScopeSpec `yaml:",omitempty"` // as transitioned by scope actions
+	Test       string
+	Frames     []RunFrame // top-level test followed by nested tests
+	Vars       map[string]string
+	Captured   map[string]interface{}
+	Containers []string
+	Updated    string
+}
+
+// progress of a test within its loop
+type RunFrame struct {
+	Test   string
+	Loop   int
+	Action int // index of last completed action
+}
+
+func NewRunState(flags TestFlags) *RunState {
+	return &RunState{
+		File:   flags.RunStateFile(),
+		Run:    RunID,
+		Scope:  *flags.ScopeFile,
+		Test:   *flags.TestFile,
+		Frames: []RunFrame{},
+	}
+}
+
+func LoadRunState(fileName string) *RunState {
+	state := RunState{}
+	ReadYamlFile(fileName, &state)
+	state.File = fileName
+	return &state
+}
+
+// frame of test at depth, a frame saved for the
+// same test is kept so that it can be resumed
+func (r *RunState) Enter(depth int, test string) *RunFrame {
+	if depth >= len(r.Frames) || r.Frames[depth].Test != test {
+		r.Frames = append(r.Frames[:depth], RunFrame{Test: test, Action: -1})
+	}
+	return &r.Frames[depth]
+}
+
+// drop frame of test at depth once it returns
+func (r *RunState) Leave(depth int) {
+	if depth < len(r.Frames) {
+		r.Frames = r.Frames[:depth]
+	}
+}
+
+// record progress of test at depth and persist to state file
+func (r *RunState) Save(scope *Scope, cm *ContainerManager, depth, loop, action int) {
+
+	frame := &r.Frames[depth]
+	if loop != frame.Loop || action > frame.Action {
+		// past resume point, frames of nested tests are done
+		r.Frames = r.Frames[:depth+1]
+	}
+	frame.Loop = loop
+	frame.Action = action
+	r.Vars = make(map[string]string)
+	for item := range scope.Vars.Iter() {
+		r.Vars[item.Key] = item.Val.(string)
+	}
+	r.Captured = make(map[string]interface{})
+	for item := range scope.Captured.Iter() {
+		r.Captured[item.Key] = item.Val
+	}
+	spec := scope.Spec
+	r.Spec = &spec
+	r.Containers = append([]string{}, cm.IDs...)
+	r.Updated = TimeStamp()
+
+	out, err := yaml.Marshal(r)
+	logerr(err)
+	err = os.MkdirAll(filepath.Dir(r.File), 0777)
+	logerr(err)
+	err = ioutil.WriteFile(r.File, out, 0644)
+	logerr(err)
+}
+
+// move frame at depth to start of next loop
+func (r *RunState) NextLoop(depth, loop int) {
+	r.Frames = r.Frames[:depth+1]
+	r.Frames[depth].Loop = loop
+	r.Frames[depth].Action = -1
+}
+
+// state is no longer needed once test completes
+func (r *RunState) Remove() {
+	os.Remove(r.File)
+}
+
+// restore scope spec and vars and reattach to containers still running
+func (t *Test) Resume(scope *Scope) *RunState {
+
+	state := LoadRunState(t.Flags.RunStateFile())
+
+	// scope actions skipped on resume have already
+	// transitioned the cluster to the saved spec
+	if state.Spec != nil {
+		scope.Spec = *state.Spec
+	}
+	for key, id := range state.Vars {
+		scope.SetVarsKV(key, id)
+	}
+	for key, val := range state.Captured {
+		scope.SetCapturedVar(key, val)
+	}
+
+	for _, id := range state.Containers {
+		echan, err := t.Cm.ReattachContainer(id)
+		if err != nil {
+			continue // container is gone or exited
+		}
+		colorsay("reattached to " + id[:6])
+		go t.WatchErrorChan(echan, 1, scope)
+	}
+
+	for _, frame := range state.Frames {
+		colorsay(fmt.Sprintf("resuming %s at loop %d after action %d",
+			frame.Test, frame.Loop, frame.Action))
+	}
+	return state
+}
@@ -0,0 +1,215 @@
+package sequoia
+
+/* Suite.go
+ *
+ * Runs a suite of tests where each entry has
+ * its own test state. Clusters are reused when
+ * consecutive entries share the same scope.
+ */
+
+import (
+	"fmt"
+	"github.com/streamrail/concurrent-map"
+	"gopkg.in/yaml.v2"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type SuiteEntry struct {
+	Name  string
+	Scope string
+	Test  string
+	Flags string
+}
+
+type SuiteResult struct {
+	Name     string
+	Scope    string
+	Test     string
+	Status   string
+	Failures int
+	Duration string
+}
+
+type Suite struct {
+	Entries []SuiteEntry
+	Flags   TestFlags
+	Results []SuiteResult
+}
+
+func NewSuite(flags TestFlags) Suite {
+
+	var entries []SuiteEntry
+	ReadYamlFile(*flags.SuiteFile, &entries)
+
+	// entries without scope continue on previous scope
+	scopeFile := *flags.ScopeFile
+	for i, entry := range entries {
+		if entry.Scope == "" {
+			entries[i].Scope = scopeFile
+		}
+		scopeFile = entries[i].Scope
+		if entry.Name == "" {
+			name := PathToFilename(entry.Test)
+			entries[i].Name = strings.TrimSuffix(name, path.Ext(name))
+		}
+	}
+
+	return Suite{
+		Entries: entries,
+		Flags:   flags,
+		Results: []SuiteResult{},
+	}
+}
+
+// entries selected by -only and -skip filters
+func (s *Suite) FilterEntries() []SuiteEntry {
+	entries := []SuiteEntry{}
+	for _, entry := range s.Entries {
+		if *s.Flags.Only != "" && !MatchesAnyName(entry.Name, *s.Flags.Only) {
+			continue
+		}
+		if *s.Flags.Skip != "" && MatchesAnyName(entry.Name, *s.Flags.Skip) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// matches name against comma separated list of names or patterns
+func MatchesAnyName(name, patterns string) bool {
+	for _, pattern := range CommaStrToList(patterns) {
+		if ok, _ := path.Match(pattern, name); ok == true {
+			return true
+		}
+	}
+	return false
+}
+
+// suite level flags are passed down to entries
+// followed by entry flags which take precedence
+func (s *Suite) EntryFlags(entry SuiteEntry) TestFlags {
+	args := s.Flags.SetArgs(s.Flags.SuiteFlagSet, "suite", "only", "skip")
+	args = append(args, "-scope", entry.Scope, "-test", entry.Test)
+	args = append(args, strings.Fields(entry.Flags)...)
+	return NewTestFlagsFromArgs(args)
+}
+
+func (s *Suite) Run() {
+
+	entries := s.FilterEntries()
+	cm := NewContainerManager(*s.Flags.Client, *s.Flags.Provider)
+
+	var scope Scope
+	ready := false
+	for i, entry := range entries {
+		flags := s.EntryFlags(entry)
+		sharesPrev := ready == true && i > 0 && entries[i-1].Scope == entry.Scope
+		sharesNext := i+1 < len(entries) && entries[i+1].Scope == entry.Scope
+
+		if sharesNext == true {
+			// keep cluster for next entry
+			*flags.SkipTeardown = true
+			*flags.SkipCleanup = true
+		}
+
+		var result SuiteResult
+		result, ready = s.RunEntry(entry, flags, cm, &scope, sharesPrev)
+		s.Results = append(s.Results, result)
+	}
+
+	s.WriteSummary()
+}
+
+func (s *Suite) RunEntry(entry SuiteEntry, flags TestFlags, cm *ContainerManager, scope *Scope, sharesPrev bool) (result SuiteResult, ready bool) {
+
+	result = SuiteResult{
+		Name:  entry.Name,
+		Scope: entry.Scope,
+		Test:  entry.Test,
+	}
+	start := time.Now()
+	colorsay(fmt.Sprintf("suite entry %s: %s", entry.Name, entry.Test))
+
+	// fatal errors of entry or of goroutines it
+	// started abort only this entry
+	fatal := make(chan interface{}, 1)
+	SetFatalChan(fatal)
+	defer func() {
+		SetFatalChan(nil)
+		result.Status = "pass"
+		if result.Failures > 0 {
+			result.Status = "fail"
+		}
+		result.Duration = time.Since(start).String()
+	}()
+
+	test := NewTest(flags, cm)
+	test.Isolated = true
+
+	var setup int32
+	done := make(chan bool)
+	go func() {
+		defer RecoverFatal()
+		if sharesPrev == true {
+			s.ReuseScope(scope, flags)
+		} else {
+			*scope = NewScope(flags, cm)
+		}
+		atomic.StoreInt32(&setup, 1)
+		test.Run(*scope)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		result.Failures = int(atomic.LoadInt32(&test.Failures))
+		return result, true
+	case r := <-fatal:
+		ecolorsay(fmt.Sprintf("suite entry %s aborted: %v", entry.Name, r))
+		// signal remaining actions of entry not to run
+		test.Stop()
+		result.Failures = int(atomic.LoadInt32(&test.Failures)) + 1
+		return result, atomic.LoadInt32(&setup) == 1
+	}
+}
+
+// reuses cluster of previous entry with fresh scope state,
+// the spec is rebuilt from entry flags so that its vars and
+// overrides are transitioned onto the cluster
+func (s *Suite) ReuseScope(scope *Scope, flags TestFlags) {
+	*flags.SkipSetup = true
+	scope.Flags = flags
+	scope.Vars = cmap.New()
+	scope.Captured = cmap.New()
+	scope.Loops = 0
+	scope.TransitionScope(ScopeSpecFromFlags(flags))
+}
+
+// print and save pass/fail/duration of each entry
+func (s *Suite) WriteSummary() {
+
+	passed := 0
+	for _, result := range s.Results {
+		msg := fmt.Sprintf("%-4s %-30s %s (%d failures)",
+			result.Status, result.Name, result.Duration, result.Failures)
+		if result.Status == "pass" {
+			passed++
+			colorsay(msg)
+		} else {
+			ecolorsay(msg)
+		}
+	}
+	colorsay(fmt.Sprintf("%d/%d suite entries passed", passed, len(s.Results)))
+
+	out, err := yaml.Marshal(s.Results)
+	logerr(err)
+	f := CreateFile(*s.Flags.LogDir, "suite_summary.yml")
+	defer f.Close()
+	_, err = f.Write(out)
+	logerr(err)
+	colorsay("summary saved to " + f.Name())
+}
@@ -0,0 +1,434 @@
+package sequoia
+
+/* Teardown.go
+ *
+ * Undoes scope setup in reverse order so that the
+ * cluster returns to a single initialized node.  Each
+ * step is verified against the cluster and reported
+ * as its own test point.
+ */
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const TEARDOWN_TIMEOUT = 10 * time.Minute
+
+func (s *Scope) Teardown() {
+	// descope
+	s.TeardownStep("remove xdcr", s.RemoveXdcr, s.VerifyXdcrRemoved)
+	s.TeardownStep("drop fts indexes", s.DropFtsIndexes, s.VerifyFtsIndexesDropped)
+	s.TeardownStep("drop indexes", s.DropIndexes, s.VerifyIndexesDropped)
+	s.TeardownStep("drop ddocs", s.DropViews, s.VerifyViewsDropped)
+	s.TeardownStep("delete users", s.DeleteUsers, s.VerifyUsersDeleted)
+	s.TeardownStep("delete buckets", s.DeleteBuckets, s.VerifyBucketsDeleted)
+	s.TeardownStep("remove nodes", s.RemoveNodes, s.VerifyNodesRemoved)
+	s.TeardownStep("remove server groups", s.RemoveServerGroups, s.VerifyServerGroupsRemoved)
+}
+
+// runs step and reports verification as test point
+func (s *Scope) TeardownStep(desc string, step func(), verify func() error) {
+	step()
+
+	var err error
+	if verify != nil {
+		err = verify()
+	}
+	if err != nil {
+		msg := UtilTaskMsg("[teardown]", fmt.Sprintf("%s: %s", desc, err))
+		ecolorsay(msg)
+		s.Cm.TapHandle.Ok(false, msg)
+	} else {
+		msg := UtilTaskMsg("[teardown]", desc)
+		colorsay(msg)
+		s.Cm.TapHandle.Ok(true, msg)
+	}
+}
+
+// polls check until it passes or teardown timeout
+func WaitForTeardown(check func() error) error {
+	start := time.Now()
+	for {
+		err := check()
+		if err == nil || time.Since(start) > TEARDOWN_TIMEOUT {
+			return err
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (s *Scope) VerifyXdcrRemoved() error {
+	for _, remote := range s.Spec.Xdcr.AllRemotes() {
+		from := s.Spec.ForCluster(remote.From)
+		if len(from.Names) == 0 {
+			continue
+		}
+		rest := s.Provider.GetRestUrl(from.Names[0])
+		remotes, err := GetRemoteClusters(rest, from.RestUsername, from.RestPassword)
+		if err != nil {
+			return err
+		}
+		for _, r := range remotes {
+			if r.Name == remote.Name && r.Deleted == false {
+				return fmt.Errorf("remote %s still exists on %s", remote.Name, from.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scope) DropFtsIndexes() {
+
+	operation := func(name string, server *ServerSpec) {
+
+		indexes := s.ClusterFtsIndexes(server)
+		if len(indexes) == 0 {
+			return
+		}
+		ftsNode, ok := s.ServiceNode(server, "fts")
+		if ok == false {
+			return
+		}
+		ftsHost := FtsHost(s.Provider.GetHostAddress(ftsNode))
+
+		for _, index := range indexes {
+			err := DeleteFtsIndex(ftsHost, server.RestUsername, server.RestPassword, index.Name)
+			if err != nil {
+				ecolorsay(fmt.Sprintf("fts index delete %s: %s", index.Name, err))
+			}
+		}
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
+
+func (s *Scope) VerifyFtsIndexesDropped() error {
+	for i, _ := range s.Spec.Servers {
+		server := &s.Spec.Servers[i]
+		indexes := s.ClusterFtsIndexes(server)
+		if len(indexes) == 0 {
+			continue
+		}
+		ftsNode, ok := s.ServiceNode(server, "fts")
+		if ok == false {
+			continue
+		}
+		ftsHost := FtsHost(s.Provider.GetHostAddress(ftsNode))
+		names, err := GetFtsIndexNames(ftsHost, server.RestUsername, server.RestPassword)
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			if InList(index.Name, names) {
+				return fmt.Errorf("fts index %s still exists", index.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scope) DropIndexes() {
+
+	operation := func(name string, server *ServerSpec) {
+
+		if server.HasIndexes() == false {
+			return
+		}
+		queryNode, ok := s.ServiceNode(server, "n1ql")
+		if ok == false {
+			ecolorsay("cannot drop indexes without query node in cluster " + server.Name)
+			return
+		}
+
+		for _, bucket := range server.BucketSpecs {
+			for _, bucketName := range bucket.Names {
+				for _, index := range bucket.IndexSpecs {
+					if index.Bucket != bucket.Name && index.Bucket != bucketName {
+						continue
+					}
+					stmt := index.DropStatement(bucketName)
+					s.RunStatement("index drop "+index.Name, stmt, server, queryNode)
+				}
+			}
+		}
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
+
+func (s *Scope) VerifyIndexesDropped() error {
+	for _, server := range s.Spec.Servers {
+		if server.HasIndexes() == false {
+			continue
+		}
+		rest := s.Provider.GetRestUrl(server.Names[0])
+		err := WaitForTeardown(func() error {
+			statuses, err := GetIndexStatus(rest, server.RestUsername, server.RestPassword)
+			if err != nil {
+				return err
+			}
+			for _, bucket := range server.BucketSpecs {
+				for _, bucketName := range bucket.Names {
+					for _, index := range bucket.IndexSpecs {
+						for _, status := range statuses {
+							if index.InKeyspace(status, bucketName) && status.Index == index.Name {
+								return fmt.Errorf("index %s.%s still exists",
+									index.Keyspace(bucketName), index.Name)
+							}
+						}
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scope) DropViews() {
+
+	var image = "appropriate/curl"
+
+	operation := func(name string, server *ServerSpec) {
+
+		orchestrator := server.Names[0]
+		ip := strings.Split(s.Provider.GetHostAddress(orchestrator), ":")[0]
+
+		for _, bucket := range server.BucketSpecs {
+			for _, bucketName := range bucket.Names {
+				for _, ddoc := range bucket.DDocSpecs {
+					viewUrl := fmt.Sprintf("http://%s:%s/%s/_design/%s",
+						ip, server.ViewPort, bucketName, ddoc.Name)
+					task := ContainerTask{
+						Describe: "views delete " + bucketName + "/" + ddoc.Name,
+						Image:    image,
+						Command: []string{"-s", "-X", "DELETE",
+							"-u", server.RestUsername + ":" + server.RestPassword,
+							viewUrl},
+						Async: false,
+					}
+					if s.Provider.GetType() == "docker" {
+						task.LinksTo = orchestrator
+					}
+					s.Cm.Run(&task)
+				}
+			}
+		}
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
+
+func (s *Scope) VerifyViewsDropped() error {
+	for _, server := range s.Spec.Servers {
+		rest := s.Provider.GetRestUrl(server.Names[0])
+		for _, bucket := range server.BucketSpecs {
+			if len(bucket.DDocSpecs) == 0 {
+				continue
+			}
+			for _, bucketName := range bucket.Names {
+				ddocs, err := GetDDocNames(rest, server.RestUsername, server.RestPassword, bucketName)
+				if err != nil {
+					return err
+				}
+				for _, ddoc := range bucket.DDocSpecs {
+					if InList(ddoc.Name, ddocs) {
+						return fmt.Errorf("ddoc %s/%s still exists", bucketName, ddoc.Name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scope) DeleteUsers() {
+
+	if len(s.Spec.Users) == 0 {
+		return
+	}
+
+	operation := func(name string, server *ServerSpec) {
+
+		orchestrator := server.Names[0]
+		ip := s.Provider.GetHostAddress(orchestrator)
+
+		for _, user := range s.Spec.Users {
+			if user.Clusters != "" && InList(server.Name, CommaStrToList(user.Clusters)) == false {
+				continue
+			}
+			command := []string{"user-manage", "-c", ip,
+				"-u", server.RestUsername, "-p", server.RestPassword,
+				"--delete",
+				"--rbac-username", user.Username,
+				"--auth-domain", "local",
+			}
+			s.RunCliTask("user delete "+user.Username, command, orchestrator)
+		}
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
+
+func (s *Scope) VerifyUsersDeleted() error {
+	if len(s.Spec.Users) == 0 {
+		return nil
+	}
+	for _, server := range s.Spec.Servers {
+		rest := s.Provider.GetRestUrl(server.Names[0])
+		names, err := GetRbacUserNames(rest, server.RestUsername, server.RestPassword)
+		if err != nil {
+			return err
+		}
+		for _, user := range s.Spec.Users {
+			if user.Clusters != "" && InList(server.Name, CommaStrToList(user.Clusters)) == false {
+				continue
+			}
+			if InList(user.Username, names) {
+				return fmt.Errorf("user %s still exists on %s", user.Username, server.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scope) VerifyBucketsDeleted() error {
+	for _, server := range s.Spec.Servers {
+		rest := s.Provider.GetRestUrl(server.Names[0])
+		err := WaitForTeardown(func() error {
+			names, err := GetBucketNames(rest, server.RestUsername, server.RestPassword)
+			if err != nil {
+				return err
+			}
+			for _, bucket := range server.BucketSpecs {
+				for _, bucketName := range bucket.Names {
+					if InList(bucketName, names) {
+						return fmt.Errorf("bucket %s still exists", bucketName)
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waits for rebalance to finish with only the
+// orchestrator remaining in each cluster
+func (s *Scope) VerifyNodesRemoved() error {
+	for _, server := range s.Spec.Servers {
+		rest := s.Provider.GetRestUrl(server.Names[0])
+		err := WaitForTeardown(func() error {
+			status, err := GetRebalanceStatus(rest, server.RestUsername, server.RestPassword)
+			if err != nil {
+				return err
+			}
+			if status != "none" {
+				colorsay(fmt.Sprintf("rebalance %s on cluster %s", status, server.Name))
+				return fmt.Errorf("rebalance %s", status)
+			}
+			var pool PoolsDefault
+			err = getPoolsDefault(rest, server.RestUsername, server.RestPassword, &pool)
+			if err != nil {
+				return err
+			}
+			if len(pool.Nodes) > 1 {
+				return fmt.Errorf("cluster %s has %d nodes", server.Name, len(pool.Nodes))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moves orchestrator back to default group
+// and deletes the then empty server groups
+func (s *Scope) RemoveServerGroups() {
+
+	operation := func(name string, server *ServerSpec) {
+
+		if len(server.GroupNames()) == 0 {
+			return
+		}
+		orchestrator := server.Names[0]
+		ip := s.Provider.GetHostAddress(orchestrator)
+
+		group := server.NodeGroups[orchestrator]
+		if group != "" && group != DEFAULT_SERVER_GROUP {
+			command := []string{"group-manage", "-c", ip,
+				"-u", server.RestUsername, "-p", server.RestPassword,
+				"--move-servers", ip + ":" + server.RestPort,
+				"--from-group", group,
+				"--to-group", DEFAULT_SERVER_GROUP,
+			}
+			desc := "group move " + orchestrator + " to " + DEFAULT_SERVER_GROUP
+			if err := s.RunCliTask(desc, command, orchestrator); err != nil {
+				// group of orchestrator cannot be deleted
+				return
+			}
+		}
+
+		for _, group := range server.GroupNames() {
+			if group == DEFAULT_SERVER_GROUP {
+				continue
+			}
+			command := []string{"group-manage", "-c", ip,
+				"-u", server.RestUsername, "-p", server.RestPassword,
+				"--delete", "--group-name", group,
+			}
+			s.RunCliTask("group delete "+group, command, orchestrator)
+		}
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
+
+func (s *Scope) VerifyServerGroupsRemoved() error {
+	for _, server := range s.Spec.Servers {
+		if len(server.GroupNames()) == 0 {
+			continue
+		}
+		rest := s.Provider.GetRestUrl(server.Names[0])
+		names, err := GetServerGroupNames(rest, server.RestUsername, server.RestPassword)
+		if err != nil {
+			return err
+		}
+		for _, group := range server.GroupNames() {
+			if group != DEFAULT_SERVER_GROUP && InList(group, names) {
+				return fmt.Errorf("server group %s still exists on %s", group, server.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// teardown mode, undoes scope setup on an existing cluster
+func RunTeardown(flags TestFlags) {
+
+	cm := NewContainerManager(*flags.Client, *flags.Provider)
+	scope := NewScope(flags, cm)
+	if (scope.Provider.GetType() != "docker") &&
+		(scope.Provider.GetType() != "swarm") {
+		// non-dynamic IP's need to be extrapolated
+		scope.Provider.ProvideCouchbaseServers(scope.Spec.Servers)
+	}
+	scope.InitCli()
+	scope.Teardown()
+	cm.TapHandle.AutoPlan()
+}
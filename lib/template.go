@@ -16,35 +16,49 @@ import (
 )
 
 type TemplateResolver struct {
-	Scope *Scope
+	Scope        *Scope
+	ClusterIndex int // cluster used by shortcuts
 }
 
 func ParseTemplate(s *Scope, command string) string {
-
-	tResolv := TemplateResolver{s}
-
-	netFunc := template.FuncMap{
-		"net":      tResolv.Address,
-		"bucket":   tResolv.BucketName,
-		"noport":   tResolv.NoPort,
-		"json":     tResolv.ToJson,
-		"ftoint":   tResolv.FloatToInt,
-		"last":     tResolv.LastItem,
-		"contains": tResolv.Contains,
-		"excludes": tResolv.Excludes,
-		"tolist":   tResolv.ToList,
-		"strlist":  tResolv.StrList,
-		"mkrange":  tResolv.MkRange,
-		"to_ip":    tResolv.ToIp,
-	}
-	tmpl, err := template.New("t").Funcs(netFunc).Parse(command)
+	out, err := RenderTemplate(s, command)
 	logerr(err)
+	return out
+}
+
+// render template returning any parse or execution error
+func RenderTemplate(s *Scope, command string) (string, error) {
+
+	tResolv := TemplateResolver{Scope: s}
+	tmpl, err := template.New("t").Funcs(tResolv.FuncMap()).Parse(command)
+	if err != nil {
+		return "", err
+	}
 
 	out := new(bytes.Buffer)
 	err = tmpl.Execute(out, &tResolv)
-	logerr(err)
-
-	return fmt.Sprintf("%s", out)
+	return fmt.Sprintf("%s", out), err
+}
+
+func (t *TemplateResolver) FuncMap() template.FuncMap {
+	funcs := template.FuncMap{
+		"net":      t.Address,
+		"bucket":   t.BucketName,
+		"noport":   t.NoPort,
+		"json":     t.ToJson,
+		"ftoint":   t.FloatToInt,
+		"last":     t.LastItem,
+		"contains": t.Contains,
+		"excludes": t.Excludes,
+		"tolist":   t.ToList,
+		"strlist":  t.StrList,
+		"mkrange":  t.MkRange,
+		"to_ip":    t.ToIp,
+	}
+	for name, fn := range t.GeneralFuncMap() {
+		funcs[name] = fn
+	}
+	return funcs
 }
 
 func (t *TemplateResolver) Version() float64 {
@@ -86,8 +100,7 @@ func (t *TemplateResolver) Service(service string, servers []ServerSpec) []Serve
 	for _, spec := range servers {
 		added := false
 		for _, name := range spec.Names {
-			rest := t.Scope.Provider.GetRestUrl(name)
-			ok := NodeHasService(service, rest, spec.RestUsername, spec.RestPassword)
+			ok := t.Scope.NodeHasService(name, service)
 			if ok == true {
 				if added == false {
 					serviceNodes = append(serviceNodes, ServerSpec{Names: []string{name}})
@@ -108,7 +121,7 @@ func (t *TemplateResolver) Service(service string, servers []ServerSpec) []Serve
 		for _, spec := range servers {
 			for name, services := range spec.NodeServices {
 				for _, nodeService := range services {
-					if nodeService == service {
+					if nodeService == SpecServiceName(service) {
 						serviceNodes = append(serviceNodes, ServerSpec{Names: []string{name}})
 					}
 				}
@@ -118,6 +131,19 @@ func (t *TemplateResolver) Service(service string, servers []ServerSpec) []Serve
 	return serviceNodes
 }
 
+// nodes assigned to server group, ie..
+// {{.ClusterNodes | .Group `rack2` | net 0}}
+func (t *TemplateResolver) Group(group string, servers []ServerSpec) []ServerSpec {
+	groupNodes := []ServerSpec{}
+	for _, spec := range servers {
+		names := spec.GroupNodeNames(group)
+		if len(names) > 0 {
+			groupNodes = append(groupNodes, ServerSpec{Names: names})
+		}
+	}
+	return groupNodes
+}
+
 func (t *TemplateResolver) Nodes() []ServerSpec {
 	return t.Scope.Spec.Servers
 }
@@ -127,8 +153,25 @@ func (t *TemplateResolver) Cluster(index int, servers []ServerSpec) []ServerSpec
 }
 
 // Shortcut: .Nodes | .Cluster 0
+// or cluster selected by .ClusterByName
 func (t *TemplateResolver) ClusterNodes() []ServerSpec {
-	return t.Cluster(0, t.Nodes())
+	return t.Cluster(t.ClusterIndex, t.Nodes())
+}
+
+// resolver with shortcuts applied to named cluster, ie..
+// {{with .ClusterByName `remote`}}{{.Orchestrator}}{{end}}
+func (t *TemplateResolver) ClusterByName(name string) (*TemplateResolver, error) {
+	for i, spec := range t.Nodes() {
+		if spec.Name == name {
+			return &TemplateResolver{Scope: t.Scope, ClusterIndex: i}, nil
+		}
+	}
+	return nil, fmt.Errorf("no cluster named %q", name)
+}
+
+// name of cluster used by shortcuts
+func (t *TemplateResolver) ClusterName() string {
+	return t.ClusterNodes()[0].Name
 }
 
 // Retreive just hostnames from ServerSpec object
@@ -148,21 +191,21 @@ func (t *TemplateResolver) NodeAddresses(servers []ServerSpec) []string {
 	ips := []string{}
 	names := t.NodeNames(servers)
 	for _, name := range names {
-		ip := t.Scope.Provider.GetHostAddress(name)
+		ip := t.Scope.HostAddress(name)
 		ips = append(ips, ip)
 	}
 	return ips
 }
 
 func (t *TemplateResolver) ToIp(name string) string {
-	return t.Scope.Provider.GetHostAddress(name)
+	return t.Scope.HostAddress(name)
 }
 
 // Shortcut: .ClusterNodes | net 0
 func (t *TemplateResolver) Orchestrator() string {
 	nodes := t.ClusterNodes()
 	name := nodes[0].Names[0]
-	val := t.Scope.Provider.GetHostAddress(name)
+	val := t.Scope.HostAddress(name)
 	return val
 }
 
@@ -220,6 +263,42 @@ func (t *TemplateResolver) NthDataNode(n int) string {
 	return t.Address(n, nodes)
 }
 
+// Shortcut: .ClusterNodes | .Group `name` | addresses
+// for failing over all nodes of a group
+func (t *TemplateResolver) GroupNodes(group string) []string {
+	nodes := t.ClusterNodes()
+	return t.NodeAddresses(t.Group(group, nodes))
+}
+
+// Shortcut: .ClusterNodes | .Service `eventing` | net 0
+func (t *TemplateResolver) EventingNode() string {
+	return t.NthEventingNode(0)
+}
+
+func (t *TemplateResolver) NthEventingNode(n int) string {
+	nodes := t.ClusterNodes()
+	serviceNodes := t.Service("eventing", nodes)
+	return t.Address(n, serviceNodes)
+}
+
+// Shortcut: .ClusterNodes | .Service `cbas` | net 0
+func (t *TemplateResolver) AnalyticsNode() string {
+	return t.NthAnalyticsNode(0)
+}
+
+func (t *TemplateResolver) NthAnalyticsNode(n int) string {
+	nodes := t.ClusterNodes()
+	serviceNodes := t.Service("cbas", nodes)
+	return t.Address(n, serviceNodes)
+}
+
+// Shortcut: .ClusterNodes | .Service `backup` | net 0
+func (t *TemplateResolver) BackupNode() string {
+	nodes := t.ClusterNodes()
+	serviceNodes := t.Service("backup", nodes)
+	return t.Address(0, serviceNodes)
+}
+
 func (t *TemplateResolver) Attr(key string, servers []ServerSpec) string {
 	attr := t.Scope.Spec.ToAttr(key)
 	spec := reflect.ValueOf(servers[0])
@@ -300,10 +379,9 @@ func (t *TemplateResolver) NodesByAvailability(servers []ServerSpec, isActive bo
 	ips := []string{}
 	for _, spec := range servers {
 		for _, name := range spec.Names {
-			rest := t.Scope.Provider.GetRestUrl(name)
-			active := !NodeIsSingle(rest, spec.RestUsername, spec.RestPassword)
+			active := t.Scope.NodeIsActive(name)
 			if active == isActive {
-				ip := t.Scope.Provider.GetHostAddress(name)
+				ip := t.Scope.HostAddress(name)
 				ips = append(ips, ip)
 			}
 		}
@@ -342,7 +420,7 @@ func (t *TemplateResolver) NodeFromClusterByAvailability(n int, isActive bool) s
 	if numNodes > 0 {
 		// omitting orchestrator if possible
 		ip = nodes[0]
-		if ip == t.Orchestrator() && numNodes > 1 {
+		if ip == t.Address(0, servers) && numNodes > 1 {
 			ip = nodes[1]
 		}
 	}
@@ -350,14 +428,14 @@ func (t *TemplateResolver) NodeFromClusterByAvailability(n int, isActive bool) s
 	return ip
 }
 
-// Get ONE node from FIRST cluster that is Active
+// Get ONE node from FIRST (or selected) cluster that is Active
 func (t *TemplateResolver) ActiveNode() string {
-	return t.NodeFromClusterByAvailability(0, true)
+	return t.NodeFromClusterByAvailability(t.ClusterIndex, true)
 }
 
-// Get ONE node from FIRST cluster that is InActive
+// Get ONE node from FIRST (or selected) cluster that is InActive
 func (t *TemplateResolver) InActiveNode() string {
-	return t.NodeFromClusterByAvailability(0, false)
+	return t.NodeFromClusterByAvailability(t.ClusterIndex, false)
 }
 
 // Template function: `net`
@@ -367,7 +445,7 @@ func (t *TemplateResolver) Address(index int, servers []ServerSpec) string {
 	}
 
 	var name = servers[0].Names[index]
-	return t.Scope.Provider.GetHostAddress(name)
+	return t.Scope.HostAddress(name)
 }
 
 // Template function: `bucket`
@@ -396,6 +474,84 @@ func (t *TemplateResolver) NthBucket(n int) string {
 	return t.BucketName(n, t.ClusterNodes())
 }
 
+// find spec of bucket within cluster
+func (t *TemplateResolver) BucketSpecByName(name string) (BucketSpec, bool) {
+	for _, spec := range t.ClusterNodes() {
+		for _, bucketSpec := range spec.BucketSpecs {
+			for _, bucketName := range bucketSpec.Names {
+				if bucketName == name {
+					return bucketSpec, true
+				}
+			}
+		}
+	}
+	return BucketSpec{}, false
+}
+
+// nth scope of .Bucket
+func (t *TemplateResolver) ScopeName(n int) string {
+	bucket, _ := t.BucketSpecByName(t.Bucket())
+	names := bucket.ScopeNames()
+	if n >= len(names) {
+		return "<scope_not_found>"
+	}
+	return names[n]
+}
+
+// scope qualified collection of .Bucket, ie..
+// {{.Collection 0 1}} = scope.collection-2
+func (t *TemplateResolver) Collection(scopeIdx, collIdx int) string {
+	bucket, _ := t.BucketSpecByName(t.Bucket())
+	names := bucket.CollectionNames(scopeIdx)
+	if collIdx >= len(names) {
+		return "<collection_not_found>"
+	}
+	return t.ScopeName(scopeIdx) + "." + names[collIdx]
+}
+
+// bucket.scope.collection if declared in spec, ie..
+// {{.Keyspace .Bucket `scope` `collection-1`}}
+func (t *TemplateResolver) Keyspace(bucket, scope, collection string) string {
+	bucketSpec, ok := t.BucketSpecByName(bucket)
+	if ok == true {
+		for _, keyspace := range bucketSpec.Keyspaces(bucket) {
+			if keyspace.Scope == scope && keyspace.Collection == collection {
+				return keyspace.String()
+			}
+		}
+	}
+	return "<keyspace_not_found>"
+}
+
+// names of indexes declared on bucket, ie..
+// {{.IndexNames .Bucket | join `,`}}
+func (t *TemplateResolver) IndexNames(bucket string) []string {
+	names := []string{}
+	bucketSpec, _ := t.BucketSpecByName(bucket)
+	for _, index := range bucketSpec.IndexSpecs {
+		if index.Bucket == bucketSpec.Name || index.Bucket == bucket {
+			names = append(names, index.Name)
+		}
+	}
+	return names
+}
+
+// username of declared user
+func (t *TemplateResolver) User(name string) string {
+	if user, ok := t.Scope.Spec.ForUser(name); ok == true {
+		return user.Username
+	}
+	return "<user_not_found>"
+}
+
+// password of declared user
+func (t *TemplateResolver) UserPassword(name string) string {
+	if user, ok := t.Scope.Spec.ForUser(name); ok == true {
+		return user.Password
+	}
+	return "<user_not_found>"
+}
+
 // strip port from addr
 func (t *TemplateResolver) NoPort(addr string) string {
 	return strings.Split(addr, ":")[0]
@@ -489,6 +645,20 @@ func (t *TemplateResolver) Status(idRef string) string {
 	return status
 }
 
+// returns exit code of container id, an unknown id or
+// removed container is an error of the calling condition
+func (t *TemplateResolver) ExitCode(idRef string) (int, error) {
+	ID, ok := t.Scope.GetVarsKV(idRef)
+	if ok == false {
+		return 0, fmt.Errorf("no such container alias %s", idRef)
+	}
+	code, err := t.Scope.Cm.GetExitCode(ID)
+	if err != nil {
+		return 0, fmt.Errorf("exit code of %s: %s", idRef, err)
+	}
+	return code, nil
+}
+
 func (t *TemplateResolver) DDoc(name string) string {
 	val := "<ddoc_not_found>"
 	for _, ddoc := range t.Scope.Spec.DDocs {
@@ -498,3 +668,15 @@ func (t *TemplateResolver) DDoc(name string) string {
 	}
 	return val
 }
+
+// returns value captured from action output
+// or else var of test and scope files
+func (t *TemplateResolver) Var(name string) interface{} {
+	if val, ok := t.Scope.GetCapturedVar(name); ok == true {
+		return val
+	}
+	if val, ok := t.Scope.Spec.Vars[name]; ok == true {
+		return val
+	}
+	return "<var_not_found>"
+}
@@ -2,18 +2,26 @@ package sequoia
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Test struct {
-	Templates map[string][]ActionSpec
+	Templates map[string]TemplateSpec
 	Actions   []ActionSpec
+	Vars      map[string]string
 	Flags     TestFlags
 	Cm        *ContainerManager
 	CollMgr   *CollectionManager
+	Failures  int32
+	Isolated  bool // stop test instead of exiting on error
+	stopped   int32
+	state     *RunState // owned by top-level test
+	depth     int       // nesting of running test
 }
 
 type CollectionManager struct {
@@ -22,26 +30,32 @@ type CollectionManager struct {
 }
 
 type ActionSpec struct {
-	Describe    string
-	Image       string
-	Command     string
-	Wait        bool
-	CondWait    string
-	Before      string
-	Entrypoint  string
-	Requires    string
-	Concurrency string
-	Duration    string
-	Alias       string
-	Repeat      int
-	Until       string
-	Include     string
-	Template    string
-	Args        string
-	Test        string
-	Scope       string
-	ForEach     string
-	Client      ClientActionSpec
+	Describe      string
+	Image         string
+	Command       string
+	Wait          bool
+	CondWait      string
+	Before        string
+	Entrypoint    string
+	Requires      string
+	Concurrency   string
+	Duration      string
+	Alias         string
+	Repeat        int
+	Until         string
+	UntilTimeout  string `yaml:"until_timeout"`
+	BeforeTimeout string `yaml:"before_timeout"`
+	PollInterval  string `yaml:"poll_interval"`
+	Include       string
+	Template      string
+	Args          string
+	Test          string
+	Scope         string
+	ForEach       string
+	Client        ClientActionSpec
+	Capture       []CaptureSpec
+	Assert        []string
+	With          map[string]interface{}
 }
 
 // returns yaml formattable string
@@ -59,20 +73,31 @@ func (a *ActionSpec) String() string {
  duration: %q
  alias: %q
  repeat: %d
+ until_timeout: %q
+ before_timeout: %q
+ poll_interval: %q
  template: %q
  args: %q
  client: %v
 `, a.Image, a.Command, a.Wait, a.CondWait, a.Before, a.Entrypoint, a.Requires,
 		a.Concurrency, a.Duration, a.Alias, a.Repeat,
-		a.Template, a.Args, a.Client)
+		a.UntilTimeout, a.BeforeTimeout, a.PollInterval, a.Template, a.Args, a.Client)
 }
 
 type TemplateSpec struct {
 	Name    string
+	Params  []ParamSpec
 	Actions []ActionSpec
 	ForEach string
 }
 
+type ParamSpec struct {
+	Name     string
+	Type     string
+	Default  string
+	Required bool
+}
+
 type ClientActionSpec struct {
 	Op        string
 	Container string
@@ -91,12 +116,56 @@ func (c ClientActionSpec) String() string {
 		c.FromPath, c.ToPath)
 }
 
+// test file with vars block followed by
+// actions block of its list of actions
+type TestFileSpec struct {
+	Vars    map[string]interface{}
+	Actions []ActionSpec
+}
+
 func ActionsFromFile(fileName string) []ActionSpec {
-	var actions []ActionSpec
-	ReadYamlFile(fileName, &actions)
+	actions, _ := TestFromFile(fileName)
 	return actions
 }
 
+// actions and vars of test file that is either
+// a list of actions or has vars and actions blocks
+func TestFromFile(fileName string) ([]ActionSpec, map[string]string) {
+	source, err := ioutil.ReadFile(fileName)
+	chkerr(err)
+
+	var contents interface{}
+	DoUnmarshal(source, &contents)
+	colorsay("parsed " + fileName)
+
+	vars := make(map[string]string)
+	if _, ok := contents.(map[interface{}]interface{}); ok == false {
+		var actions []ActionSpec
+		DoUnmarshal(source, &actions)
+		return actions, vars
+	}
+
+	var spec TestFileSpec
+	DoUnmarshal(source, &spec)
+	for k, v := range spec.Vars {
+		vars[k] = fmt.Sprintf("%v", v)
+	}
+	return spec.Actions, vars
+}
+
+// test vars override vars of scope
+// but not those set by -var flags
+func (t *Test) ApplyVars(scope *Scope) {
+	if scope.Spec.Vars == nil {
+		scope.Spec.Vars = make(map[string]string)
+	}
+	for k, v := range t.Vars {
+		if _, ok := t.Flags.Vars[k]; ok == false {
+			scope.Spec.Vars[k] = v
+		}
+	}
+}
+
 func ActionsFromArgs(image string, command string, wait bool) []ActionSpec {
 	action := ActionSpec{
 		Image:   image,
@@ -115,8 +184,9 @@ func ActionsFromString(actionStr string) []ActionSpec {
 func NewTest(flags TestFlags, cm *ContainerManager) Test {
 
 	// define test actions from config and flags
-	var templates = make(map[string][]ActionSpec)
+	var templates = make(map[string]TemplateSpec)
 	var actions []ActionSpec
+	var vars = make(map[string]string)
 	switch flags.Mode {
 	case "image":
 		actions = ActionsFromArgs(*flags.ImageName, *flags.ImageCommand, *flags.ImageWait)
@@ -143,16 +213,29 @@ func NewTest(flags TestFlags, cm *ContainerManager) Test {
 			*flags.LogLevel = 0
 		}
 	default:
-		actions = ActionsFromFile(*flags.TestFile)
+		actions, vars = TestFromFile(*flags.TestFile)
 	}
 
+	// resources of test are labeled with run id
+	colorsay(fmt.Sprintf("run %s, sequoia %s", RunID, SequoiaVersion))
+
 	ch := []chan bool{}
 	chmgr := CollectionManager{ch, 0}
-	return Test{templates, actions, flags, cm, &chmgr}
+	return Test{
+		Templates: templates,
+		Actions:   actions,
+		Vars:      vars,
+		Flags:     flags,
+		Cm:        cm,
+		CollMgr:   &chmgr,
+	}
 }
 
 func (t *Test) Run(scope Scope) {
 
+	// vars of test file for templates
+	t.ApplyVars(&scope)
+
 	// do optional setup
 	if *t.Flags.SkipSetup == false {
 		// if in default mode purge all containers
@@ -184,9 +267,21 @@ func (t *Test) Run(scope Scope) {
 	// run at least <repeat> times or forever if -1
 	// run can be terminated if Duration flag set
 	repeat := *t.Flags.Repeat
-	loops := 0
 	duration := *t.Flags.Duration
 
+	// track progress of test in case it needs to be resumed,
+	// nested tests record their progress on the same state
+	owner := t.state == nil
+	if owner == true {
+		t.state = NewRunState(t.Flags)
+		if t.Flags.Mode == "resume" {
+			t.state = t.Resume(&scope)
+		}
+	}
+	state := t.state
+	depth := t.depth
+	loops := state.Enter(depth, *t.Flags.TestFile).Loop
+
 	if duration > 0 {
 		go t.ExitAfterDuration(duration)
 		if repeat == 0 {
@@ -196,22 +291,30 @@ func (t *Test) Run(scope Scope) {
 
 	if repeat == -1 {
 		// run forever
-		for {
-			t.runActions(scope, loops, t.Actions)
+		for t.Stopped() == false {
+			t.runActionsWithState(scope, loops, t.Actions, state)
 			// kill test containers
 			t.DoContainerCleanup(scope)
 
 			loops++
+			state.NextLoop(depth, loops)
 		}
 	} else {
 		repeat++
-		for loops = 0; loops < repeat; loops++ {
-			t.runActions(scope, loops, t.Actions)
+		for ; loops < repeat && t.Stopped() == false; loops++ {
+			t.runActionsWithState(scope, loops, t.Actions, state)
 			// kill test containers
 			t.DoContainerCleanup(scope)
+			state.NextLoop(depth, loops+1)
 		}
 	}
-	t.Cm.TapHandle.AutoPlan()
+	state.Leave(depth)
+	if owner == true {
+		if t.Stopped() == false {
+			state.Remove()
+		}
+		t.state = nil
+	}
 
 	// wait if collect is happening
 	t.WaitForCollect()
@@ -220,6 +323,7 @@ func (t *Test) Run(scope Scope) {
 	if *t.Flags.SkipTeardown == false {
 		scope.Teardown()
 	}
+	t.Cm.TapHandle.AutoPlan()
 
 	// do optional cleanup
 	if *t.Flags.SkipCleanup == false {
@@ -236,12 +340,37 @@ func (t *Test) WaitForCollect() {
 }
 
 func (t *Test) runActions(scope Scope, loop int, actions []ActionSpec) {
+	t.runActionsWithState(scope, loop, actions, nil)
+}
+
+// run actions and save progress to run state when provided,
+// actions completed before test was resumed are skipped
+func (t *Test) runActionsWithState(scope Scope, loop int, actions []ActionSpec, state *RunState) {
 
 	var lastAction ActionSpec
 	scope.Loops = scope.Loops + loop
 
 	// run all actions in test
-	for _, action := range actions {
+	for i, action := range actions {
+
+		if t.Stopped() == true {
+			return
+		}
+
+		if state != nil {
+			frame := state.Frames[t.depth]
+			if i <= frame.Action && action.Include == "" {
+				// completed before resume, though
+				// includes are needed for templates
+				if action.Image != "" {
+					lastAction = action
+				}
+				continue
+			}
+			if i > frame.Action {
+				state.Save(&scope, t.Cm, t.depth, loop, i-1)
+			}
+		}
 
 		if action.ForEach != "" {
 			// resolve foreach template (must result in an iterable)
@@ -272,7 +401,11 @@ func (t *Test) runActions(scope Scope, loop int, actions []ActionSpec) {
 				}
 			case "cp":
 				// allow parsing of topath
-				action.Client.ToPath = ParseTemplate(&scope, action.Client.ToPath)
+				toPath, ok := t.RenderActionField(&scope, "topath", action.Client.ToPath)
+				if ok == false {
+					continue
+				}
+				action.Client.ToPath = toPath
 				if id, ok := scope.GetVarsKV(key); ok {
 					t.Cm.CopyFromContainer(id,
 						PathToFilename(action.Client.ToPath),
@@ -312,52 +445,48 @@ func (t *Test) runActions(scope Scope, loop int, actions []ActionSpec) {
 
 		if action.Scope != "" {
 			// transform cluster scope
-			newSpec := NewScopeSpec(action.Scope)
-			scope.Teardown()
-			if scope.Provider.GetType() == "docker" {
-				for i, s := range newSpec.Servers {
-					if i <= len(scope.Spec.Servers) { // same num of clusters
-						s.Count -= scope.Spec.Servers[i].Count
-						s.InitNodes -= scope.Spec.Servers[i].InitNodes
-						if s.Count < 0 {
-							s.Count = 0
-						}
-						if s.InitNodes < 0 {
-							s.InitNodes = 0
-						}
-						offset := newSpec.Servers[i].Count - s.Count
-						newSpec.Servers[i].CountOffset = offset
-						newSpec.Servers[i].Count = s.Count
-						newSpec.Servers[i].InitNodes = s.InitNodes
-					}
-				}
-			}
-			scope.Spec = newSpec
-			scope.Provider.ProvideCouchbaseServers(scope.Spec.Servers)
-			scope.Setup()
+			spec := NewScopeSpecWithVars(action.Scope, t.Flags.Vars, *t.Flags.Scale)
+			scope.TransitionScope(spec)
+			t.ApplyVars(&scope)
 		}
 		if action.Test != "" {
 			// referencing external test
-			testActions := ActionsFromFile(action.Test)
+			testActions, testVars := TestFromFile(action.Test)
+			actions, vars := t.Actions, t.Vars
 			t.Actions = testActions
+			t.Vars = testVars
+
+			// vars of nested test are not seen by this test
+			nestedScope := scope
+			nestedScope.Spec.Vars = make(map[string]string)
+			for k, v := range scope.Spec.Vars {
+				nestedScope.Spec.Vars[k] = v
+			}
 
 			// save test options
 			setup := t.Flags.SkipSetup
 			teardown := t.Flags.SkipTeardown
 			cleanup := t.Flags.SkipCleanup
+			testFile := t.Flags.TestFile
 
 			ok := true
 			t.Flags.SkipSetup = &ok
 			t.Flags.SkipTeardown = &ok
 			t.Flags.SkipCleanup = &ok
+			t.Flags.TestFile = &action.Test
 
-			// run test
-			t.Run(scope)
+			// run test as frame of this test
+			t.depth++
+			t.Run(nestedScope)
+			t.depth--
 
 			// restore options
 			t.Flags.SkipSetup = setup
 			t.Flags.SkipTeardown = teardown
 			t.Flags.SkipCleanup = cleanup
+			t.Flags.TestFile = testFile
+			t.Actions = actions
+			t.Vars = vars
 			continue
 		}
 
@@ -375,8 +504,8 @@ func (t *Test) runActions(scope Scope, loop int, actions []ActionSpec) {
 
 		if action.Template != "" {
 			// run template actions
-			if templateActions, ok := t.Templates[action.Template]; ok {
-				templateActions = t.ResolveTemplateActions(scope, action)
+			if _, ok := t.Templates[action.Template]; ok {
+				templateActions := t.ResolveTemplateActions(scope, action)
 				t.runActions(scope, loop, templateActions)
 			} else {
 				ecolorsay("WARNING template not found: " + action.Template)
@@ -404,9 +533,10 @@ func (t *Test) runActions(scope Scope, loop int, actions []ActionSpec) {
 
 		// check action requirements
 		if action.Requires != "" {
-			ok := ParseTemplate(&scope, action.Requires)
-			pass, err := strconv.ParseBool(ok)
-			logerr(err)
+			pass, rv, err := EvalCondition(&scope, action.Requires)
+			if err != nil {
+				t.ReportConditionError(&scope, "requires", action.Requires, rv, err)
+			}
 			if pass == false {
 				lastAction = action
 				continue
@@ -414,32 +544,56 @@ func (t *Test) runActions(scope Scope, loop int, actions []ActionSpec) {
 		}
 
 		if action.CondWait != "" {
-			ok := ParseTemplate(&scope, action.CondWait)
-			ok = strings.TrimSpace(ok)
-			if wait, err := strconv.ParseBool(ok); err == nil {
-				action.Wait = wait
+			wait, rv, err := EvalCondition(&scope, action.CondWait)
+			if err != nil {
+				t.ReportConditionError(&scope, "wait", action.CondWait, rv, err)
+				lastAction = action
+				continue
 			}
+			action.Wait = wait
 		}
 
 		// resolve command
-		command := scope.CompileCommand(action.Command)
+		command, err := scope.RenderCommand(action.Command)
+		if err != nil {
+			t.ReportConditionError(&scope, "command", action.Command, "", err)
+			lastAction = action
+			continue
+		}
 
 		// resolve duration and concurrency
 		var taskDuration time.Duration = 0
 		var taskConcurrency = 0
-		var err error
 		if action.Duration != "" {
 			// parse template if units not found
-			if strings.Index(action.Duration, "ns") == -1 {
-				action.Duration = fmt.Sprintf("%s%s", ParseTemplate(&scope, action.Duration), "ns")
+			duration := action.Duration
+			if strings.Index(duration, "ns") == -1 {
+				rv, ok := t.RenderActionField(&scope, "duration", duration)
+				if ok == false {
+					lastAction = action
+					continue
+				}
+				duration = fmt.Sprintf("%s%s", strings.TrimSpace(rv), "ns")
+			}
+			taskDuration, err = time.ParseDuration(duration)
+			if err != nil {
+				t.ReportConditionError(&scope, "duration", action.Duration, duration, err)
+				lastAction = action
+				continue
 			}
-			taskDuration, err = time.ParseDuration(action.Duration)
-			logerr(err)
 		}
 		if action.Concurrency != "" {
-			action.Concurrency = ParseTemplate(&scope, action.Concurrency)
-			taskConcurrency, err = strconv.Atoi(action.Concurrency)
-			logerr(err)
+			rv, ok := t.RenderActionField(&scope, "concurrency", action.Concurrency)
+			if ok == false {
+				lastAction = action
+				continue
+			}
+			taskConcurrency, err = strconv.Atoi(strings.TrimSpace(rv))
+			if err != nil {
+				t.ReportConditionError(&scope, "concurrency", action.Concurrency, rv, err)
+				lastAction = action
+				continue
+			}
 		}
 
 		if action.Describe == "" { // use command as describe
@@ -469,7 +623,7 @@ func (t *Test) runActions(scope Scope, loop int, actions []ActionSpec) {
 
 		// run task
 		if task.Async == true {
-			go t.runTask(&scope, &task, &action)
+			GoRecover(func() { t.runTask(&scope, &task, &action) })
 		} else {
 			t.runTask(&scope, &task, &action)
 		}
@@ -478,6 +632,9 @@ func (t *Test) runActions(scope Scope, loop int, actions []ActionSpec) {
 		time.Sleep(5 * time.Second)
 	}
 
+	if state != nil {
+		state.Save(&scope, t.Cm, t.depth, loop, len(actions)-1)
+	}
 }
 
 func (t *Test) runTask(scope *Scope, task *ContainerTask, action *ActionSpec) {
@@ -493,18 +650,27 @@ func (t *Test) runTask(scope *Scope, task *ContainerTask, action *ActionSpec) {
 		aliasKey = RandStr(6)
 	} else {
 		// parse alias
-		aliasKey = ParseTemplate(scope, aliasKey)
+		rv, ok := t.RenderActionField(scope, "alias", aliasKey)
+		if ok == false {
+			return
+		}
+		aliasKey = rv
 	}
 
 	// if command has 'before' then cannot start processing until ready
 	if actionBefore != "" {
-		var ready = false
-		var err error
-		for ready == false {
-			before := ParseTemplate(scope, actionBefore)
-			ready, err = strconv.ParseBool(before)
-			logerr(err)
-			time.Sleep(5 * time.Second)
+		timeout, err := ParseWaitDuration(scope, action.BeforeTimeout)
+		if err != nil {
+			t.ReportConditionError(scope, "before_timeout", action.BeforeTimeout, "", err)
+			return
+		}
+		interval, err := ParsePollInterval(scope, action.PollInterval, 5*time.Second)
+		if err != nil {
+			t.ReportConditionError(scope, "poll_interval", action.PollInterval, "", err)
+			return
+		}
+		if t.WaitForCondition(scope, "before", actionBefore, timeout, interval) == false {
+			return
 		}
 	}
 
@@ -514,15 +680,39 @@ func (t *Test) runTask(scope *Scope, task *ContainerTask, action *ActionSpec) {
 	}
 
 	if action.Until != "" {
+		timeout, err := ParseWaitDuration(scope, action.UntilTimeout)
+		if err != nil {
+			t.ReportConditionError(scope, "until_timeout", action.UntilTimeout, "", err)
+			return
+		}
+		interval, err := ParsePollInterval(scope, action.PollInterval, 1*time.Second)
+		if err != nil {
+			t.ReportConditionError(scope, "poll_interval", action.PollInterval, "", err)
+			return
+		}
+
 		// start until watcher
-		go t.watchTask(scope, task, aliasKey, action.Until, uChan)
+		go t.watchTask(scope, task, aliasKey, action.Until, timeout, interval, uChan)
 	}
 
 	// run once
 	cid, echan := t.Cm.Run(task)
 	scope.SetVarsKV(aliasKey, cid)
+	if IsTopologyCommand(task.Command) {
+		// cluster membership is changing
+		scope.Topology.Invalidate()
+	}
 	go t.WatchErrorChan(echan, task.Concurrency, scope)
 
+	// capture output and check assertions after container exits
+	if len(action.Capture) > 0 || len(action.Assert) > 0 {
+		if task.Async == true {
+			GoRecover(func() { t.HandleActionResults(scope, cid, aliasKey, action.Capture, action.Assert) })
+		} else {
+			t.HandleActionResults(scope, cid, aliasKey, action.Capture, action.Assert)
+		}
+	}
+
 	go t.RepeatTask(scope, cid, repeat, rChan)
 	if repeat > 0 {
 		// waiting on finite number of repeats
@@ -541,12 +731,13 @@ func (t *Test) runTask(scope *Scope, task *ContainerTask, action *ActionSpec) {
 func (t *Test) CacheIncludedTemplate(scope Scope, spec []TemplateSpec) {
 
 	for _, template := range spec {
-		if template.ForEach != "" {
+		if template.ForEach != "" && len(template.Params) == 0 {
 			// this template is within a range loop
-			// so extrapolate actions
+			// so extrapolate actions, templates with params
+			// are extrapolated when params are bound
 			template.Actions = t.ResolveTemplateRangeActions(scope, template.Actions, template.ForEach)
 		}
-		t.Templates[template.Name] = template.Actions
+		t.Templates[template.Name] = template
 	}
 }
 
@@ -554,91 +745,99 @@ func (t *Test) CacheIncludedTemplate(scope Scope, spec []TemplateSpec) {
 func (t *Test) ResolveTemplateActions(scope Scope, action ActionSpec) []ActionSpec {
 
 	var resolvedActions = []ActionSpec{}
-	var cachedActions = t.Templates[action.Template]
-
-	for _, subAction := range cachedActions {
-
-		// replace generics args ie $1, $2 with test values
-		args := ParseTemplate(&scope, action.Args)
-		allArgs := strings.Split(args, ",")
-		multiArg := false
-		lastArg := ""
-		argOffset := 0
-		for i, arg := range allArgs {
-			arg = strings.TrimSpace(arg)
-			if strings.Index(arg, "(") != -1 {
-				// this is a multi arg string
-				// concatentate until we reach ")"
-				multiArg = true
-				lastArg = strings.Replace(arg, "(", "", 1)
-				argOffset++
-				continue
-			}
-			if multiArg == true {
-				arg = fmt.Sprintf("%s,%s", lastArg, arg)
-				lastArg = arg
-				if strings.Index(arg, ")") != -1 {
-					// end of multi arg string
-					arg = strings.Replace(arg, ")", "", 1)
-					multiArg = false
-				} else {
-					argOffset++
-					continue // still building arg
-				}
-			}
-
-			idx := fmt.Sprintf("$%d", i-argOffset)
+	var template = t.Templates[action.Template]
 
-			// reformat action to string
-			actionStr := fmt.Sprintf("%s", &subAction)
-
-			// replace any magic vars ... ie $0, $1 with args
-			actionStr = strings.Replace(actionStr, idx, arg, -1)
-			subAction.Until = strings.Replace(subAction.Until, idx, arg, -1)
-			subAction.Before = strings.Replace(subAction.Before, idx, arg, -1)
-			subAction.Requires = strings.Replace(subAction.Requires, idx, arg, -1)
+	if len(template.Params) > 0 {
+		// args are bound to declared params
+		return t.ResolveTemplateParams(scope, action, template)
+	}
 
-			// unmarshal string back to action array
-			var resolvedActions = []ActionSpec{}
-			DoUnmarshal([]byte(actionStr), &resolvedActions)
-			resolvedSubAction := resolvedActions[0]
+	// positional args are bound to implicit params $0..$n
+	args := SplitTemplateArgs(ParseTemplate(&scope, action.Args))
+	values := PositionalParamValues(args)
+	for _, subAction := range template.Actions {
+		values.BindPositional(&subAction, args)
+		t.InheritActionValues(action, &subAction)
+		resolvedActions = append(resolvedActions, subAction)
+	}
 
-			// restore keys lost during unmarshal
-			resolvedSubAction.ForEach = subAction.ForEach
-			t.RestoreConditionalValues(subAction, &resolvedSubAction)
-			subAction = resolvedSubAction
-		}
+	return resolvedActions
+}
 
-		// allow inheritance
-		if subAction.Wait == false {
-			subAction.Wait = action.Wait
-		}
-		if subAction.Before == "" {
-			subAction.Before = action.Before
-		}
-		if subAction.Requires == "" {
-			subAction.Requires = action.Requires
-		}
-		if subAction.Concurrency == "" {
-			subAction.Concurrency = action.Concurrency
-		}
-		if subAction.Duration == "" {
-			subAction.Duration = action.Duration
-		}
-		if subAction.Alias == "" {
-			subAction.Alias = action.Alias
-		}
-		if subAction.Repeat == 0 {
-			subAction.Repeat = action.Repeat
+// split comma separated template args where multi arg
+// values are grouped in parenthesis, ie.. a, (b, c), d
+func SplitTemplateArgs(args string) []string {
+
+	allArgs := strings.Split(args, ",")
+	resolvedArgs := []string{}
+	multiArg := false
+	lastArg := ""
+	for _, arg := range allArgs {
+		arg = strings.TrimSpace(arg)
+		if strings.Index(arg, "(") != -1 {
+			// this is a multi arg string
+			// concatentate until we reach ")"
+			multiArg = true
+			lastArg = strings.Replace(arg, "(", "", 1)
+			continue
 		}
-		if subAction.Until == "" {
-			subAction.Until = action.Until
+		if multiArg == true {
+			arg = fmt.Sprintf("%s,%s", lastArg, arg)
+			lastArg = arg
+			if strings.Index(arg, ")") != -1 {
+				// end of multi arg string
+				arg = strings.Replace(arg, ")", "", 1)
+				multiArg = false
+			} else {
+				continue // still building arg
+			}
 		}
-
-		resolvedActions = append(resolvedActions, subAction)
+		resolvedArgs = append(resolvedArgs, arg)
 	}
+	return resolvedArgs
+}
 
-	return resolvedActions
+// allow template actions to inherit values of calling action
+func (t *Test) InheritActionValues(action ActionSpec, subAction *ActionSpec) {
+	if subAction.Wait == false {
+		subAction.Wait = action.Wait
+	}
+	if subAction.Before == "" {
+		subAction.Before = action.Before
+	}
+	if subAction.Requires == "" {
+		subAction.Requires = action.Requires
+	}
+	if subAction.Concurrency == "" {
+		subAction.Concurrency = action.Concurrency
+	}
+	if subAction.Duration == "" {
+		subAction.Duration = action.Duration
+	}
+	if subAction.Alias == "" {
+		subAction.Alias = action.Alias
+	}
+	if subAction.Repeat == 0 {
+		subAction.Repeat = action.Repeat
+	}
+	if subAction.Until == "" {
+		subAction.Until = action.Until
+	}
+	if subAction.UntilTimeout == "" {
+		subAction.UntilTimeout = action.UntilTimeout
+	}
+	if subAction.BeforeTimeout == "" {
+		subAction.BeforeTimeout = action.BeforeTimeout
+	}
+	if subAction.PollInterval == "" {
+		subAction.PollInterval = action.PollInterval
+	}
+	if len(subAction.Capture) == 0 {
+		subAction.Capture = action.Capture
+	}
+	if len(subAction.Assert) == 0 {
+		subAction.Assert = action.Assert
+	}
 }
 
 func (t *Test) ResolveSingleRangeActions(scope Scope, action ActionSpec) []ActionSpec {
@@ -664,7 +863,10 @@ func (t *Test) ResolveTemplateRangeActions(scope Scope, actions []ActionSpec, ra
 	rangeTemplate = fmt.Sprintf("%s\n{{end}}", rangeTemplate)
 
 	// compile the range template with nested action spec
-	compiledTemplate := ParseTemplate(&scope, rangeTemplate)
+	compiledTemplate, ok := t.RenderActionField(&scope, "foreach", rangeTemplate)
+	if ok == false {
+		return resolvedActions
+	}
 	// convert the result from yaml back to action array
 	DoUnmarshal([]byte(compiledTemplate), &resolvedActions)
 
@@ -694,35 +896,59 @@ func (t *Test) RestoreConditionalValues(originalAction ActionSpec, action *Actio
 	action.Until = originalAction.Until
 	action.Before = originalAction.Before
 	action.Requires = originalAction.Requires
+	action.Capture = originalAction.Capture
+	action.Assert = originalAction.Assert
+	action.With = originalAction.With
 }
 
 func (t *Test) WatchErrorChan(echan chan error, n int, scope *Scope) {
+	defer RecoverFatal()
 	if n == 0 {
 		n = 1
 	}
 	for i := 0; i < n; i++ {
 		if err := <-echan; err != nil {
-			if *t.Flags.CollectOnError == true {
+			t.HandleError(scope)
+		}
+	}
+	close(echan)
+}
 
-				// add a new collect channel
-				ch := make(chan bool)
-				t.CollMgr.Ch = append(t.CollMgr.Ch, ch)
-				t.CollMgr.ActiveCollections = len(t.CollMgr.Ch)
+// collect and/or stop test when an error occurs
+func (t *Test) HandleError(scope *Scope) {
+	atomic.AddInt32(&t.Failures, 1)
 
-				// start collect
-				t.CollectInfo(*scope)
-				ch <- true
-			}
+	if *t.Flags.CollectOnError == true {
 
-			if *t.Flags.StopOnError == true {
-				// print test results
-				t.Cm.TapHandle.AutoPlan()
-				// exit
-				os.Exit(0)
-			}
+		// add a new collect channel
+		ch := make(chan bool)
+		t.CollMgr.Ch = append(t.CollMgr.Ch, ch)
+		t.CollMgr.ActiveCollections = len(t.CollMgr.Ch)
+
+		// start collect
+		t.CollectInfo(*scope)
+		ch <- true
+	}
+
+	if *t.Flags.StopOnError == true {
+		if t.Isolated == true {
+			t.Stop()
+			return
 		}
+		// print test results
+		t.Cm.TapHandle.AutoPlan()
+		// exit
+		os.Exit(0)
 	}
-	close(echan)
+}
+
+// signal remaining actions not to run
+func (t *Test) Stop() {
+	atomic.StoreInt32(&t.stopped, 1)
+}
+
+func (t *Test) Stopped() bool {
+	return atomic.LoadInt32(&t.stopped) == 1
 }
 
 func (t *Test) CollectInfo(scope Scope) {
@@ -755,6 +981,7 @@ func (t *Test) KillTaskContainers(task *ContainerTask) {
 }
 
 func (t *Test) RepeatTask(scope *Scope, cid string, repeat int, done chan bool) {
+	defer RecoverFatal()
 	// run repeat num times
 	for repeat != 0 {
 		// only start if it stopped
@@ -775,33 +1002,137 @@ func (t *Test) RepeatTask(scope *Scope, cid string, repeat int, done chan bool)
 
 }
 
-func (t *Test) watchTask(scope *Scope, task *ContainerTask, aliasKey string, condition string, done chan bool) {
-	var _done bool
-	var err error
+func (t *Test) watchTask(scope *Scope, task *ContainerTask, aliasKey string, condition string,
+	timeout, interval time.Duration, done chan bool) {
+	defer RecoverFatal()
 
-	// replace instances of self with savekey
-	for _done == false {
+	start := time.Now()
 
+	// replace instances of self with savekey
+	condition = strings.Replace(condition, "__self__", aliasKey, -1)
+	for {
 		id, ok := scope.GetVarsKV(aliasKey)
 		if ok == true {
 			// make sure we have not been killed by 'duration' or 'repeat' conditions
 			if _, err := scope.Cm.GetStatus(id); err != nil {
 				break
 			}
-			condition = strings.Replace(condition, "__self__", aliasKey, -1)
-			rv := ParseTemplate(scope, condition)
-			rv = strings.TrimSpace(rv)
-			_done, err = strconv.ParseBool(rv)
-			logerr(err)
-			time.Sleep(1 * time.Second)
+			_done, rv, err := EvalCondition(scope, condition)
+			if err != nil {
+				t.ReportConditionError(scope, "until", condition, rv, err)
+				break
+			}
+			if _done == true {
+				break
+			}
+			if timeout > 0 && time.Since(start) >= timeout {
+				t.ReportTimeout(scope, "until", condition, rv, timeout)
+				break
+			}
 		}
+		time.Sleep(interval)
 	}
 	done <- true
 }
 
+// polls condition until true, false is returned when
+// timeout expires or condition does not render to a bool
+func (t *Test) WaitForCondition(scope *Scope, kind, condition string, timeout, interval time.Duration) bool {
+
+	start := time.Now()
+	for {
+		ready, rv, err := EvalCondition(scope, condition)
+		if err != nil {
+			t.ReportConditionError(scope, kind, condition, rv, err)
+			return false
+		}
+		if ready == true {
+			return true
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			t.ReportTimeout(scope, kind, condition, rv, timeout)
+			return false
+		}
+		time.Sleep(interval)
+	}
+}
+
+// render condition which must result in a bool
+func EvalCondition(scope *Scope, condition string) (bool, string, error) {
+	rv, err := RenderTemplate(scope, condition)
+	if err != nil {
+		return false, "", err
+	}
+	rv = strings.TrimSpace(rv)
+	ok, err := strconv.ParseBool(rv)
+	return ok, rv, err
+}
+
+// parse optional wait duration, ie.. 30s, 10m, 2h
+func ParseWaitDuration(scope *Scope, val string) (time.Duration, error) {
+	if val == "" {
+		return 0, nil
+	}
+	val, err := RenderTemplate(scope, val)
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(strings.TrimSpace(val))
+}
+
+// interval between polls of condition, default when not set
+func ParsePollInterval(scope *Scope, val string, defaultInterval time.Duration) (time.Duration, error) {
+	if val == "" {
+		return defaultInterval, nil
+	}
+	interval, err := ParseWaitDuration(scope, val)
+	if err != nil {
+		return 0, err
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("poll interval must be positive")
+	}
+	return interval, nil
+}
+
+// render action field, errors are reported as spec
+// errors so that only the action fails and not the run
+func (t *Test) RenderActionField(scope *Scope, kind, val string) (string, bool) {
+	rv, err := RenderTemplate(scope, val)
+	if err != nil {
+		t.ReportConditionError(scope, kind, val, rv, err)
+		return rv, false
+	}
+	return rv, true
+}
+
+func (t *Test) ReportTimeout(scope *Scope, kind, condition, rv string, timeout time.Duration) {
+	msg := fmt.Sprintf("%s timed out after %s: %s, last value: %q",
+		kind, timeout, strings.Join(strings.Fields(condition), " "), rv)
+	t.ReportFailure(scope, "[timeout]", msg)
+}
+
+func (t *Test) ReportConditionError(scope *Scope, kind, condition, rv string, err error) {
+	msg := fmt.Sprintf("%s: %s rendered %q: %s",
+		kind, strings.Join(strings.Fields(condition), " "), rv, err)
+	t.ReportFailure(scope, "[spec error]", msg)
+}
+
+// record failed test point and handle as error
+func (t *Test) ReportFailure(scope *Scope, opt, msg string) {
+	msg = UtilTaskMsg(opt, msg)
+	ecolorsay(msg)
+	t.Cm.TapHandle.Ok(false, msg)
+	t.HandleError(scope)
+}
+
 func (t *Test) ExitAfterDuration(sec int) {
 	// wait
 	time.Sleep(time.Duration(sec) * time.Second)
+	if t.Isolated == true {
+		t.Stop()
+		return
+	}
 	// print test results
 	t.Cm.TapHandle.AutoPlan()
 	// exit
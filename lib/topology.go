@@ -0,0 +1,239 @@
+package sequoia
+
+/* Topology.go
+ *
+ * Cache of node addresses, services and cluster
+ * membership used by template functions.  The cache is
+ * refreshed from /pools/default of each cluster once
+ * its ttl expires or after topology changing actions.
+ */
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const TOPOLOGY_CACHE_TTL = 5 * time.Second
+
+type NodeInfo struct {
+	Name     string
+	Address  string
+	Services []string
+	Active   bool
+}
+
+type TopologyCache struct {
+	sync.Mutex
+	Addresses map[string]string
+	Nodes     map[string]NodeInfo
+	Updated   time.Time
+	TTL       time.Duration
+}
+
+func NewTopologyCache() *TopologyCache {
+	return &TopologyCache{
+		Addresses: make(map[string]string),
+		TTL:       TOPOLOGY_CACHE_TTL,
+	}
+}
+
+// forces refresh on next lookup
+func (c *TopologyCache) Invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.Updated = time.Time{}
+}
+
+// clear cached values once ttl has expired,
+// caller must hold lock
+func (c *TopologyCache) expire() {
+	if time.Since(c.Updated) > c.TTL {
+		c.Addresses = make(map[string]string)
+		c.Nodes = nil
+		c.Updated = time.Now()
+	}
+}
+
+// cached address of node, caller must hold lock
+func (s *Scope) address(name string) string {
+	ip, ok := s.Topology.Addresses[name]
+	if ok == false {
+		ip = s.Provider.GetHostAddress(name)
+		s.Topology.Addresses[name] = ip
+	}
+	return ip
+}
+
+// cached address of node
+func (s *Scope) HostAddress(name string) string {
+	c := s.Topology
+	c.Lock()
+	defer c.Unlock()
+	c.expire()
+	return s.address(name)
+}
+
+// lookup node info, fetching topology if expired
+func (s *Scope) NodeInfo(name string) NodeInfo {
+	c := s.Topology
+	c.Lock()
+	defer c.Unlock()
+	c.expire()
+
+	if c.Nodes == nil {
+		c.Nodes = s.FetchTopology()
+	}
+	info, ok := c.Nodes[name]
+	if ok == false {
+		// node not part of scope spec
+		info = NodeInfo{
+			Name:    name,
+			Address: s.address(name),
+		}
+	}
+	return info
+}
+
+// cached check of node service, service is
+// either the rest or spec name, ie.. n1ql or query
+func (s *Scope) NodeHasService(name, service string) bool {
+	service = RestServiceName(service)
+	for _, nodeService := range s.NodeInfo(name).Services {
+		if nodeService == service {
+			return true
+		}
+	}
+	return false
+}
+
+// cached check of node cluster membership
+func (s *Scope) NodeIsActive(name string) bool {
+	return s.NodeInfo(name).Active
+}
+
+// spec service names of rest service names
+var SpecServiceNames = map[string]string{
+	"kv":   "data",
+	"n1ql": "query",
+	"cbas": "analytics",
+}
+
+// rest service name of spec service name, ie.. analytics to cbas
+func RestServiceName(service string) string {
+	for restService, specService := range SpecServiceNames {
+		if specService == service {
+			return restService
+		}
+	}
+	return service
+}
+
+// spec service name of rest service name, ie.. cbas to analytics
+func SpecServiceName(service string) string {
+	if specService, ok := SpecServiceNames[service]; ok {
+		return specService
+	}
+	return service
+}
+
+// first active node of cluster with service, ie.. n1ql, fts.
+// provisioned services are used when cluster can't be reached
+func (s *Scope) ServiceNode(server *ServerSpec, service string) (string, bool) {
+	names := server.Names
+	if server.InitNodes > 0 && int(server.InitNodes) < len(names) {
+		names = names[:server.InitNodes]
+	}
+	for _, name := range names {
+		if s.NodeHasService(name, service) {
+			return name, true
+		}
+	}
+
+	specService := SpecServiceName(service)
+	for _, name := range names {
+		if InList(specService, server.NodeServices[name]) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// get info of all nodes in scope using a single request
+// per cluster, nodes that are not members of the cluster
+// are queried directly.  caller must hold lock
+func (s *Scope) FetchTopology() map[string]NodeInfo {
+
+	nodes := make(map[string]NodeInfo)
+	for _, spec := range s.Spec.Servers {
+
+		// get cluster from first node that is a member
+		var pool PoolsDefault
+		for _, name := range spec.Names {
+			rest := s.Provider.GetRestUrl(name)
+			err := getPoolsDefault(rest, spec.RestUsername, spec.RestPassword, &pool)
+			if err == nil && len(pool.Nodes) > 0 {
+				break
+			}
+			pool = PoolsDefault{}
+		}
+
+		for _, name := range spec.Names {
+			info := NodeInfo{
+				Name:    name,
+				Address: s.address(name),
+			}
+			rest := s.Provider.GetRestUrl(name)
+			if poolNode, ok := pool.FindNode(rest, info.Address); ok == true {
+				info.Services = poolNode.Services
+				// failed over and inactive added nodes are
+				// listed by cluster but are not active
+				info.Active = poolNode.ClusterMembership == "active"
+			} else {
+				var self NodeSelf
+				if err := getNodeSelf(rest, spec.RestUsername, spec.RestPassword, &self); err == nil {
+					info.Services = self.Services
+				}
+				info.Active = !NodeIsSingle(rest, spec.RestUsername, spec.RestPassword)
+			}
+			nodes[name] = info
+		}
+	}
+	return nodes
+}
+
+// find node by rest url or by address when
+// node uses the default rest port
+func (p *PoolsDefault) FindNode(rest, address string) (PoolNode, bool) {
+	for _, node := range p.Nodes {
+		if node.Hostname == rest {
+			return node, true
+		}
+	}
+	for _, node := range p.Nodes {
+		parts := strings.Split(node.Hostname, ":")
+		if parts[0] == address && (len(parts) == 1 || parts[1] == "8091") {
+			return node, true
+		}
+	}
+	return PoolNode{}, false
+}
+
+// commands that change cluster membership or services
+func IsTopologyCommand(command []string) bool {
+	for _, arg := range command {
+		switch arg {
+		case "rebalance", "failover", "server-add", "server-readd", "recovery":
+			return true
+		}
+		// rest api, ie.. curl ../controller/rebalance
+		for _, endpoint := range []string{"/controller/rebalance",
+			"/controller/failOver", "/controller/startGracefulFailover",
+			"/controller/addNode", "/controller/setRecoveryType"} {
+			if strings.Contains(arg, endpoint) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,580 @@
+package sequoia
+
+/* Transition.go
+ *
+ * Diffs the current scope spec against the spec of
+ * a scope: action and applies the minimal plan of node,
+ * bucket, quota and settings changes with a single rebalance
+ * so that data in unchanged buckets is kept.  Buckets that are
+ * created get their collections, indexes and views.  Changes that
+ * can't be applied in place, such as to indexes of an existing
+ * bucket or to users, fall back to teardown and setup.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type QuotaChange struct {
+	Flag  string
+	From  string
+	Value string
+}
+
+type ClusterPlan struct {
+	Cluster       string
+	AddNodes      []string
+	RemoveNodes   []string
+	DeleteBuckets []string
+	CreateBuckets []string
+	EditBuckets   []string
+	Quotas        []QuotaChange
+	Settings      bool
+	Rebalance     bool
+}
+
+type TransitionPlan struct {
+	Full     bool
+	Reason   string
+	Clusters []ClusterPlan
+}
+
+// nodes of server that are members of the cluster after setup
+func (s *ServerSpec) ActiveNames() []string {
+	n := int(s.InitNodes)
+	if n < 1 {
+		n = 1
+	}
+	if n > len(s.Names) {
+		n = len(s.Names)
+	}
+	return s.Names[:n]
+}
+
+// expanded bucket name to bucket spec
+func (s *ServerSpec) BucketsByName() map[string]BucketSpec {
+	buckets := make(map[string]BucketSpec)
+	for _, bucket := range s.BucketSpecs {
+		for _, bucketName := range bucket.Names {
+			buckets[bucketName] = bucket
+		}
+	}
+	return buckets
+}
+
+// sections of spec are compared by their yaml
+// so that nil and empty lists are the same
+func SameSpec(a, b interface{}) bool {
+	outA, errA := yaml.Marshal(a)
+	outB, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(outA, outB)
+}
+
+// bucket settings other than ram and replica differ
+func BucketSettingsChanged(a, b BucketSpec) bool {
+	argsA, errA := BucketEditArgs(a, "")
+	argsB, errB := BucketEditArgs(b, "")
+	return errA != nil || errB != nil || reflect.DeepEqual(argsA, argsB) == false
+}
+
+// items of a that are not in b
+func ListDiff(a, b []string) []string {
+	diff := []string{}
+	for _, item := range a {
+		if InList(item, b) == false {
+			diff = append(diff, item)
+		}
+	}
+	return diff
+}
+
+// minimal plan to transition clusters of spec from to spec to
+func DiffScopeSpecs(from, to ScopeSpec) TransitionPlan {
+
+	plan := TransitionPlan{}
+	full := func(reason string) TransitionPlan {
+		return TransitionPlan{Full: true, Reason: reason}
+	}
+
+	if len(from.Servers) != len(to.Servers) {
+		return full("number of clusters changed")
+	}
+
+	// sections that are only created during setup
+	if SameSpec(from.FtsIndexes, to.FtsIndexes) == false {
+		return full("fts indexes changed")
+	}
+	if SameSpec(from.Users, to.Users) == false {
+		return full("users changed")
+	}
+	if SameSpec(from.Xdcr, to.Xdcr) == false {
+		return full("xdcr changed")
+	}
+
+	for i, _ := range to.Servers {
+		server := &to.Servers[i]
+		old := from.ForCluster(server.Name)
+		if len(old.Names) == 0 {
+			return full("cluster " + server.Name + " is new")
+		}
+
+		// existing nodes must keep their names
+		shorter, longer := old.Names, server.Names
+		if len(shorter) > len(longer) {
+			shorter, longer = longer, shorter
+		}
+		if reflect.DeepEqual(shorter, longer[:len(shorter)]) == false {
+			return full("node names of cluster " + server.Name + " changed")
+		}
+
+		oldActive := old.ActiveNames()
+		newActive := server.ActiveNames()
+		for _, name := range newActive {
+			if InList(name, oldActive) == false {
+				continue
+			}
+			if strings.Join(old.NodeServices[name], ",") != strings.Join(server.NodeServices[name], ",") {
+				return full("services of node " + name + " changed")
+			}
+			if old.NodeGroups[name] != server.NodeGroups[name] {
+				return full("group of node " + name + " changed")
+			}
+		}
+		if SameSpec(old.Groups, server.Groups) == false {
+			return full("server groups of cluster " + server.Name + " changed")
+		}
+
+		cp := ClusterPlan{
+			Cluster:     server.Name,
+			AddNodes:    ListDiff(newActive, oldActive),
+			RemoveNodes: ListDiff(oldActive, newActive),
+		}
+
+		// changed quotas
+		oldQuotas := old.Quotas()
+		for j, q := range server.Quotas() {
+			from := *oldQuotas[j].Value
+			if *q.Value != "" && *q.Value != from {
+				cp.Quotas = append(cp.Quotas, QuotaChange{q.Flag, from, *q.Value})
+			}
+		}
+		ramChanged := server.Ram != "" && server.Ram != old.Ram
+
+		// bucket changes, changes that can't be edited recreate
+		// bucket and created buckets get their collections,
+		// indexes and views
+		oldBuckets := old.BucketsByName()
+		newBuckets := server.BucketsByName()
+		for _, bucket := range server.BucketSpecs {
+			for _, bucketName := range bucket.Names {
+				oldBucket, ok := oldBuckets[bucketName]
+				if ok == false {
+					cp.CreateBuckets = append(cp.CreateBuckets, bucketName)
+					continue
+				}
+				if oldBucket.Type != bucket.Type || oldBucket.Sasl != bucket.Sasl ||
+					ConflictResolutionType(oldBucket.ConflictResolution) !=
+						ConflictResolutionType(bucket.ConflictResolution) {
+					cp.DeleteBuckets = append(cp.DeleteBuckets, bucketName)
+					cp.CreateBuckets = append(cp.CreateBuckets, bucketName)
+					continue
+				}
+				if SameSpec(oldBucket.Scopes, bucket.Scopes) == false {
+					return full("scopes of bucket " + bucketName + " changed")
+				}
+				if SameSpec(oldBucket.IndexSpecs, bucket.IndexSpecs) == false {
+					return full("indexes of bucket " + bucketName + " changed")
+				}
+				if SameSpec(oldBucket.DDocSpecs, bucket.DDocSpecs) == false {
+					return full("views of bucket " + bucketName + " changed")
+				}
+				replicaChanged := BucketReplica(oldBucket) != BucketReplica(bucket)
+				relativeRam := strings.Index(bucket.Ram, "%") > -1 && ramChanged
+				if oldBucket.Ram != bucket.Ram || relativeRam || replicaChanged ||
+					BucketSettingsChanged(oldBucket, bucket) {
+					cp.EditBuckets = append(cp.EditBuckets, bucketName)
+				}
+				if replicaChanged == true {
+					cp.Rebalance = true
+				}
+			}
+		}
+		for _, bucket := range old.BucketSpecs {
+			for _, bucketName := range bucket.Names {
+				if _, ok := newBuckets[bucketName]; ok == false {
+					cp.DeleteBuckets = append(cp.DeleteBuckets, bucketName)
+				}
+			}
+		}
+
+		cp.Settings = server.Settings != nil && reflect.DeepEqual(old.Settings, server.Settings) == false
+		if len(cp.AddNodes) > 0 || len(cp.RemoveNodes) > 0 {
+			cp.Rebalance = true
+		}
+		plan.Clusters = append(plan.Clusters, cp)
+	}
+	return plan
+}
+
+func (p *TransitionPlan) Print() {
+	if p.Full == true {
+		colorsay("scope transition: teardown and setup, " + p.Reason)
+		return
+	}
+	for _, cp := range p.Clusters {
+		steps := []string{}
+		if len(cp.DeleteBuckets) > 0 {
+			steps = append(steps, "delete buckets "+strings.Join(cp.DeleteBuckets, ","))
+		}
+		for _, q := range cp.Quotas {
+			steps = append(steps, fmt.Sprintf("set %s %s (was %s)", q.Flag, q.Value, q.From))
+		}
+		if len(cp.EditBuckets) > 0 {
+			steps = append(steps, "edit buckets "+strings.Join(cp.EditBuckets, ","))
+		}
+		if len(cp.CreateBuckets) > 0 {
+			steps = append(steps, "create buckets "+strings.Join(cp.CreateBuckets, ","))
+		}
+		if len(cp.AddNodes) > 0 {
+			steps = append(steps, "add nodes "+strings.Join(cp.AddNodes, ","))
+		}
+		if cp.Rebalance == true {
+			rebalance := "rebalance"
+			if len(cp.RemoveNodes) > 0 {
+				rebalance += " removing " + strings.Join(cp.RemoveNodes, ",")
+			}
+			steps = append(steps, rebalance)
+		}
+		if cp.Settings == true {
+			steps = append(steps, "apply settings")
+		}
+		if len(steps) == 0 {
+			steps = append(steps, "no changes")
+		}
+		for i, step := range steps {
+			colorsay(fmt.Sprintf("scope transition %s: %d. %s", cp.Cluster, i+1, step))
+		}
+	}
+}
+
+type QuotaField struct {
+	Flag  string
+	Value *string
+}
+
+// ram quotas of server spec by cluster-init flag
+func (s *ServerSpec) Quotas() []QuotaField {
+	return []QuotaField{
+		{"--cluster-ramsize", &s.Ram},
+		{"--cluster-index-ramsize", &s.IndexRam},
+		{"--cluster-fts-ramsize", &s.FtsRam},
+		{"--cluster-eventing-ramsize", &s.EventingRam},
+		{"--cluster-analytics-ramsize", &s.AnalyticsRam},
+	}
+}
+
+// resolves relative quotas of target spec against memtotal,
+// dropping quota and bucket changes that resolve to current values
+func (s *Scope) ResolveTransitionQuotas(plan *TransitionPlan, to *ScopeSpec) {
+	for i, _ := range to.Servers {
+		server := &to.Servers[i]
+		old := s.Spec.ForCluster(server.Name)
+		orchestrator := server.Names[0]
+
+		values := make(map[string]string)
+		oldQuotas := old.Quotas()
+		for j, q := range server.Quotas() {
+			if *q.Value == "" {
+				*q.Value = *oldQuotas[j].Value
+			} else if strings.Index(*q.Value, "%") > -1 {
+				*q.Value = s.GetPercOfMemTotal(orchestrator, server, *q.Value)
+			}
+			values[q.Flag] = *q.Value
+		}
+
+		oldBuckets := old.BucketsByName()
+		newBuckets := server.BucketsByName()
+		for j, cp := range plan.Clusters {
+			if cp.Cluster != server.Name {
+				continue
+			}
+			quotas := []QuotaChange{}
+			for _, q := range cp.Quotas {
+				if values[q.Flag] != q.From {
+					quotas = append(quotas, QuotaChange{q.Flag, q.From, values[q.Flag]})
+				}
+			}
+			plan.Clusters[j].Quotas = quotas
+
+			edits := []string{}
+			for _, bucketName := range cp.EditBuckets {
+				oldBucket, bucket := oldBuckets[bucketName], newBuckets[bucketName]
+				if BucketRamQuota(&old, oldBucket) != BucketRamQuota(server, bucket) ||
+					BucketReplica(oldBucket) != BucketReplica(bucket) ||
+					BucketSettingsChanged(oldBucket, bucket) {
+					edits = append(edits, bucketName)
+				}
+			}
+			plan.Clusters[j].EditBuckets = edits
+		}
+	}
+}
+
+// transition to spec of scope: action
+func (s *Scope) TransitionScope(to ScopeSpec) {
+
+	SetProviderDefaults(&to, s.Provider)
+	s.ValidateBuckets(to)
+	s.ValidateServices(to)
+	plan := DiffScopeSpecs(s.Spec, to)
+	if plan.Full == false {
+		s.ResolveTransitionQuotas(&plan, &to)
+	}
+	plan.Print()
+
+	if plan.Full == true {
+		s.FullTransition(to)
+		return
+	}
+
+	s.ProvideTransitionNodes(plan, to)
+
+	for _, cp := range plan.Clusters {
+		server := &to.Servers[0]
+		for i, _ := range to.Servers {
+			if to.Servers[i].Name == cp.Cluster {
+				server = &to.Servers[i]
+			}
+		}
+		if err := s.ApplyClusterPlan(cp, server); err != nil {
+			// cluster is between specs
+			ecolorsay(fmt.Sprintf("cluster %s not transitioned, %s", cp.Cluster, err))
+			s.Topology.Invalidate()
+			// nodes already provided for transition are reused
+			for i, old := range s.Spec.Servers {
+				if n := len(to.ForCluster(old.Name).Names); n > len(old.Names) {
+					s.Spec.Servers[i].Count = uint8(n)
+				}
+			}
+			s.FullTransition(to)
+			return
+		}
+	}
+
+	s.Spec = to
+	s.Topology.Invalidate()
+}
+
+// provisions nodes that are new to the spec, waits for
+// them and initializes those that are added to clusters
+func (s *Scope) ProvideTransitionNodes(plan TransitionPlan, to ScopeSpec) {
+
+	newServers := []ServerSpec{}
+	addServers := []ServerSpec{}
+	for _, server := range to.Servers {
+		old := s.Spec.ForCluster(server.Name)
+		if len(server.Names) > len(old.Names) {
+			newServer := server
+			newServer.Count = uint8(len(server.Names) - len(old.Names))
+			newServer.CountOffset = uint8(len(old.Names))
+			newServer.Names = server.Names[len(old.Names):]
+			newServers = append(newServers, newServer)
+		}
+		for _, cp := range plan.Clusters {
+			if cp.Cluster == server.Name && len(cp.AddNodes) > 0 {
+				addServer := server
+				addServer.Names = cp.AddNodes
+				addServers = append(addServers, addServer)
+			}
+		}
+	}
+
+	switch s.Provider.GetType() {
+	case "docker", "swarm":
+		if len(newServers) > 0 {
+			s.Provider.ProvideCouchbaseServers(newServers)
+		}
+	default:
+		// static hosts are assigned in order of spec
+		s.Provider.ProvideCouchbaseServers(to.Servers)
+	}
+
+	if len(addServers) == 0 {
+		return
+	}
+	current := s.Spec
+	s.Spec = ScopeSpec{Servers: addServers}
+	s.WaitForNodes()
+	s.InitNodes()
+	s.Spec = current
+}
+
+// applies plan to cluster, returns error of the
+// step after which cluster could not be transitioned
+func (s *Scope) ApplyClusterPlan(cp ClusterPlan, server *ServerSpec) error {
+
+	orchestrator := server.Names[0]
+	ip := s.Provider.GetHostAddress(orchestrator)
+	buckets := server.BucketsByName()
+
+	for _, bucketName := range cp.DeleteBuckets {
+		s.DeleteBucket(server, bucketName)
+	}
+
+	// increased quotas make room for growing buckets and
+	// decreased quotas are applied once buckets have shrunk
+	increases, decreases := SplitQuotaChanges(cp.Quotas)
+	if err := s.ApplyQuotas(server, increases); err != nil {
+		return fmt.Errorf("quota increase failed")
+	}
+
+	for _, bucketName := range cp.EditBuckets {
+		bucket := buckets[bucketName]
+		settings, err := BucketEditArgs(bucket, s.Version)
+		if err != nil {
+			return fmt.Errorf("bucket edit %s: %s", bucketName, err)
+		}
+		command := []string{"bucket-edit", "-c", ip,
+			"-u", server.RestUsername, "-p", server.RestPassword,
+			"--bucket", bucketName,
+			"--bucket-ramsize", BucketRamQuota(server, bucket),
+			"--bucket-replica", BucketReplica(bucket),
+		}
+		command = append(command, settings...)
+		if err := s.RunCliTask("bucket edit "+bucketName, command, orchestrator); err != nil {
+			return fmt.Errorf("bucket edit %s failed", bucketName)
+		}
+	}
+
+	if err := s.ApplyQuotas(server, decreases); err != nil {
+		return fmt.Errorf("quota decrease failed")
+	}
+
+	for _, bucketName := range cp.CreateBuckets {
+		s.CreateBucket(server, buckets[bucketName], bucketName)
+	}
+
+	for _, name := range cp.AddNodes {
+		s.AddNode(name, server)
+	}
+
+	if cp.Rebalance == true {
+		command := []string{"rebalance", "-c", ip,
+			"-u", server.RestUsername, "-p", server.RestPassword,
+		}
+		if len(cp.RemoveNodes) > 0 {
+			ips := []string{}
+			for _, name := range cp.RemoveNodes {
+				ips = append(ips, s.Provider.GetHostAddress(name))
+			}
+			command = append(command, "--server-remove", strings.Join(ips, ","))
+		}
+		command = cliCommandValidator(s.Version, command)
+		err := s.RunCliTask("rebalance cluster "+server.Name, command, orchestrator)
+		if err != nil {
+			return fmt.Errorf("rebalance failed")
+		}
+		s.Topology.Invalidate()
+	}
+	server.NodesActive = uint8(len(server.ActiveNames()))
+
+	if len(cp.CreateBuckets) > 0 {
+		s.SetupCreatedBuckets(server, cp.CreateBuckets)
+	}
+
+	if cp.Settings == true {
+		s.applyClusterSettings(orchestrator, server, *server.Settings)
+	}
+	return nil
+}
+
+// creates collections, indexes and views of buckets
+// created by transition once nodes are rebalanced
+func (s *Scope) SetupCreatedBuckets(server *ServerSpec, bucketNames []string) {
+
+	created := *server
+	created.BucketSpecs = []BucketSpec{}
+	for _, bucket := range server.BucketSpecs {
+		names := []string{}
+		for _, bucketName := range bucket.Names {
+			if InList(bucketName, bucketNames) == true {
+				names = append(names, bucketName)
+			}
+		}
+		if len(names) > 0 {
+			bucket.Names = names
+			created.BucketSpecs = append(created.BucketSpecs, bucket)
+		}
+	}
+
+	current := s.Spec
+	s.Spec = ScopeSpec{Servers: []ServerSpec{created}}
+	s.CreateCollections()
+	s.CreateIndexes()
+	s.CreateViews()
+	s.Spec = current
+}
+
+// quota changes that increase or set a quota
+// and those that decrease it
+func SplitQuotaChanges(quotas []QuotaChange) ([]QuotaChange, []QuotaChange) {
+	increases := []QuotaChange{}
+	decreases := []QuotaChange{}
+	for _, q := range quotas {
+		from, errFrom := strconv.Atoi(q.From)
+		to, errTo := strconv.Atoi(q.Value)
+		if errFrom == nil && errTo == nil && to < from {
+			decreases = append(decreases, q)
+		} else {
+			increases = append(increases, q)
+		}
+	}
+	return increases, decreases
+}
+
+// sets quotas of cluster with setting-cluster
+func (s *Scope) ApplyQuotas(server *ServerSpec, quotas []QuotaChange) error {
+	if len(quotas) == 0 {
+		return nil
+	}
+	orchestrator := server.Names[0]
+	command := []string{"setting-cluster", "-c", s.Provider.GetHostAddress(orchestrator),
+		"-u", server.RestUsername, "-p", server.RestPassword,
+	}
+	for _, q := range quotas {
+		command = append(command, q.Flag, q.Value)
+	}
+	command = cliCommandValidator(s.Version, command)
+	return s.RunCliTask("quotas "+server.Name, command, orchestrator)
+}
+
+// teardown current scope and setup target spec,
+// docker containers of existing nodes are reused
+func (s *Scope) FullTransition(newSpec ScopeSpec) {
+	s.Teardown()
+	if s.Provider.GetType() == "docker" {
+		for i, server := range newSpec.Servers {
+			if i < len(s.Spec.Servers) { // same num of clusters
+				var count, initNodes uint8
+				if server.Count > s.Spec.Servers[i].Count {
+					count = server.Count - s.Spec.Servers[i].Count
+				}
+				if server.InitNodes > s.Spec.Servers[i].InitNodes {
+					initNodes = server.InitNodes - s.Spec.Servers[i].InitNodes
+				}
+				newSpec.Servers[i].CountOffset = server.Count - count
+				newSpec.Servers[i].Count = count
+				newSpec.Servers[i].InitNodes = initNodes
+			}
+		}
+	}
+	s.Spec = newSpec
+	s.Provider.ProvideCouchbaseServers(s.Spec.Servers)
+	s.Setup()
+	s.ReapplySettings()
+}
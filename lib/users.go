@@ -0,0 +1,51 @@
+package sequoia
+
+/* Users.go
+ *
+ * Creates rbac users declared in scope spec so that
+ * workloads can run as least privileged users
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func (s *Scope) CreateUsers() {
+
+	if len(s.Spec.Users) == 0 {
+		return
+	}
+	vMajor, _ := strconv.ParseFloat(s.Version, 64)
+	if vMajor > 0 && vMajor < 5.0 {
+		ecolorsay("rbac users require version 5.0, skipping")
+		return
+	}
+
+	operation := func(name string, server *ServerSpec) {
+
+		orchestrator := server.Names[0]
+		ip := s.Provider.GetHostAddress(orchestrator)
+
+		for _, user := range s.Spec.Users {
+			if user.Clusters != "" && InList(server.Name, CommaStrToList(user.Clusters)) == false {
+				continue
+			}
+
+			command := []string{"user-manage", "-c", ip,
+				"-u", server.RestUsername, "-p", server.RestPassword,
+				"--set",
+				"--rbac-username", user.Username,
+				"--rbac-password", user.Password,
+				"--roles", strings.Join(user.Roles, ","),
+				"--auth-domain", "local",
+			}
+			desc := fmt.Sprintf("user create %s [%s]", user.Username, strings.Join(user.Roles, ","))
+			s.RunCliTask(desc, command, orchestrator)
+		}
+	}
+
+	// apply only to orchestrator
+	s.Spec.ApplyToServers(operation, 0, 1)
+}
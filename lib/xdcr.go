@@ -0,0 +1,191 @@
+package sequoia
+
+/* Xdcr.go
+ *
+ * Creates remote cluster references and replications
+ * declared in the xdcr section of scope spec
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (s *Scope) CreateXdcr() {
+
+	// remote cluster references
+	failedRemotes := make(map[string]bool)
+	for _, remote := range s.Spec.Xdcr.AllRemotes() {
+		from := s.Spec.ForCluster(remote.From)
+		to := s.Spec.ForCluster(remote.To)
+		if len(from.Names) == 0 || len(to.Names) == 0 {
+			ecolorsay(fmt.Sprintf("xdcr remote %s: unknown cluster %s or %s",
+				remote.Name, remote.From, remote.To))
+			continue
+		}
+
+		orchestrator := from.Names[0]
+		command := []string{"xdcr-setup",
+			"-c", s.Provider.GetHostAddress(orchestrator),
+			"-u", from.RestUsername,
+			"-p", from.RestPassword,
+			"--create",
+			"--xdcr-cluster-name", remote.Name,
+			"--xdcr-hostname", s.Provider.GetHostAddress(to.Names[0]),
+			"--xdcr-username", to.RestUsername,
+			"--xdcr-password", to.RestPassword,
+		}
+		if err := s.RunCliTask("xdcr remote "+remote.Name, command, orchestrator); err != nil {
+			failedRemotes[remote.Name] = true
+		}
+	}
+
+	// replications
+	for _, repl := range s.Spec.Xdcr.AllReplications() {
+		from := s.Spec.ForCluster(repl.From)
+		if len(from.Names) == 0 {
+			ecolorsay("xdcr replication: unknown cluster " + repl.From)
+			continue
+		}
+		if failedRemotes[repl.Remote] == true {
+			ecolorsay(fmt.Sprintf("xdcr replication %s -> %s: remote %s was not created",
+				repl.From, repl.To, repl.Remote))
+			continue
+		}
+
+		pairs, err := ReplicationBuckets(repl, from)
+		if err != nil {
+			ecolorsay(err.Error())
+			continue
+		}
+
+		orchestrator := from.Names[0]
+		for _, pair := range pairs {
+			command := []string{"xdcr-replicate",
+				"-c", s.Provider.GetHostAddress(orchestrator),
+				"-u", from.RestUsername,
+				"-p", from.RestPassword,
+				"--create",
+				"--xdcr-cluster-name", repl.Remote,
+				"--xdcr-from-bucket", pair[0],
+				"--xdcr-to-bucket", pair[1],
+			}
+			if repl.Filter != "" {
+				command = append(command, "--filter-expression", repl.Filter)
+			}
+			desc := fmt.Sprintf("xdcr replicate %s/%s -> %s/%s",
+				repl.From, pair[0], repl.To, pair[1])
+			s.RunCliTask(desc, command, orchestrator)
+		}
+	}
+}
+
+func (s *Scope) RemoveXdcr() {
+
+	// replications must be removed before references
+	inUse := make(map[string]bool)
+	for _, repl := range s.Spec.Xdcr.AllReplications() {
+		from := s.Spec.ForCluster(repl.From)
+		if len(from.Names) == 0 {
+			continue
+		}
+
+		orchestrator := from.Names[0]
+		rest := s.Provider.GetRestUrl(orchestrator)
+		uuid, err := GetRemoteClusterUuid(rest, from.RestUsername, from.RestPassword, repl.Remote)
+		if err != nil {
+			ecolorsay(fmt.Sprintf("xdcr remote %s: %s", repl.Remote, err))
+			continue
+		}
+		pairs, err := ReplicationBuckets(repl, from)
+		if err != nil {
+			ecolorsay(err.Error())
+			continue
+		}
+		for _, pair := range pairs {
+			replicator := fmt.Sprintf("%s/%s/%s", uuid, pair[0], pair[1])
+			command := []string{"xdcr-replicate",
+				"-c", s.Provider.GetHostAddress(orchestrator),
+				"-u", from.RestUsername,
+				"-p", from.RestPassword,
+				"--delete",
+				"--xdcr-replicator=" + replicator,
+			}
+			desc := "xdcr delete replication " + replicator
+			if err := s.RunCliTask(desc, command, orchestrator); err != nil {
+				inUse[repl.Remote] = true
+			}
+		}
+	}
+
+	for _, remote := range s.Spec.Xdcr.AllRemotes() {
+		from := s.Spec.ForCluster(remote.From)
+		if len(from.Names) == 0 {
+			continue
+		}
+
+		if inUse[remote.Name] == true {
+			ecolorsay(fmt.Sprintf("xdcr remote %s: replications remain, not deleted", remote.Name))
+			continue
+		}
+
+		orchestrator := from.Names[0]
+		command := []string{"xdcr-setup",
+			"-c", s.Provider.GetHostAddress(orchestrator),
+			"-u", from.RestUsername,
+			"-p", from.RestPassword,
+			"--delete",
+			"--xdcr-cluster-name", remote.Name,
+		}
+		s.RunCliTask("xdcr delete remote "+remote.Name, command, orchestrator)
+	}
+}
+
+// source and target bucket of each replicated bucket
+// to_bucket can only rename a single source bucket
+func ReplicationBuckets(repl ReplicationSpec, from ServerSpec) ([][2]string, error) {
+
+	buckets := []string{}
+	if repl.Bucket != "" {
+		for _, name := range CommaStrToList(repl.Bucket) {
+			// bucket with count expands to each of its names
+			names := []string{name}
+			for _, bucket := range from.BucketSpecs {
+				if bucket.Name == name {
+					names = bucket.Names
+				}
+			}
+			buckets = append(buckets, names...)
+		}
+	} else {
+		for _, bucket := range from.BucketSpecs {
+			buckets = append(buckets, bucket.Names...)
+		}
+	}
+
+	if repl.ToBucket != "" && len(buckets) > 1 {
+		return nil, fmt.Errorf("xdcr replication %s -> %s: to_bucket %s with %d source buckets %s",
+			repl.From, repl.To, repl.ToBucket, len(buckets), strings.Join(buckets, ","))
+	}
+
+	pairs := [][2]string{}
+	for _, bucket := range buckets {
+		toBucket := bucket
+		if repl.ToBucket != "" {
+			toBucket = repl.ToBucket
+		}
+		pairs = append(pairs, [2]string{bucket, toBucket})
+	}
+	return pairs, nil
+}
+
+// convert conflict resolution to couchbase-cli value
+func ConflictResolutionType(val string) string {
+	switch val {
+	case "seqno":
+		return "sequence"
+	case "lww":
+		return "timestamp"
+	}
+	return val
+}
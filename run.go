@@ -10,6 +10,43 @@ func main() {
 	flags := S.NewTestFlags()
 	flags.Parse()
 
+	if flags.Mode == "funcs" {
+		// list functions available to test templates
+		S.PrintTemplateFuncs()
+		return
+	}
+
+	if flags.Mode == "placement" {
+		// show node services and groups of scope
+		S.PrintPlacement(S.ScopeSpecFromFlags(flags))
+		return
+	}
+
+	if flags.Mode == "config" {
+		// show flag values and where they came from
+		S.PrintConfig(flags)
+		return
+	}
+
+	if flags.Mode == "clean" {
+		// remove containers and logs of previous runs
+		S.RunClean(flags)
+		return
+	}
+
+	if flags.Mode == "teardown" {
+		// undo scope setup on existing cluster
+		S.RunTeardown(flags)
+		return
+	}
+
+	if flags.Mode == "suite" {
+		// run each suite entry as its own test
+		suite := S.NewSuite(flags)
+		suite.Run()
+		return
+	}
+
 	// configure
 	cm := S.NewContainerManager(*flags.Client, *flags.Provider)
 	scope := S.NewScope(flags, cm)